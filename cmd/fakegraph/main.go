@@ -0,0 +1,41 @@
+// Command fakegraph serves a fake Facebook Graph API over plain HTTP. Point
+// FACEBOOK_GRAPH_URL at it. The tokens file maps access tokens to users:
+//
+//	{"member-token": {"user_id": "1", "name": "Kim", "email": "kim@example.com", "scopes": ["email"]}}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook/fakegraph"
+)
+
+func main() {
+	addr := flag.String("addr", ":8091", "address to listen on")
+	appID := flag.String("app-id", "fake-app", "facebook app id")
+	appSecret := flag.String("app-secret", "fake-secret", "facebook app secret")
+	tokensFile := flag.String("tokens", "", "json file with the known tokens")
+	flag.Parse()
+
+	server := fakegraph.New(*appID, *appSecret)
+	if *tokensFile != "" {
+		content, err := os.ReadFile(*tokensFile)
+		if err != nil {
+			log.Fatalf("could not read tokens: %v", err)
+		}
+		tokens := map[string]fakegraph.Token{}
+		if err := json.Unmarshal(content, &tokens); err != nil {
+			log.Fatalf("could not parse tokens: %v", err)
+		}
+		for token, info := range tokens {
+			server.Add(token, info)
+		}
+	}
+
+	log.Printf("fake graph api listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
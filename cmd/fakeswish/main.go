@@ -0,0 +1,21 @@
+// Command fakeswish serves a fake Swish Commerce API over plain HTTP. Point
+// SWISH_COMMERCE_URL at it and leave the certificate files out.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish/fakeswish"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	delay := flag.Duration("delay", 5*time.Second, "time the payer takes to answer a payment request")
+	flag.Parse()
+
+	log.Printf("fake swish listening on %s, requests mentioning %s are declined", *addr, fakeswish.DeclineWord)
+	log.Fatal(http.ListenAndServe(*addr, fakeswish.New(*delay)))
+}
@@ -2,25 +2,64 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
+	"time"
+	// series are expanded in Europe/Stockholm, which alpine does not ship
+	_ "time/tzdata"
 
 	"cloud.google.com/go/logging"
 	"github.com/caarlos0/env"
+	appconfig "github.com/stockholmfootvolley/booking/internal/app/config"
 	"github.com/stockholmfootvolley/booking/internal/app/rest"
+	"github.com/stockholmfootvolley/booking/internal/pkg/auth"
 	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook"
+	"github.com/stockholmfootvolley/booking/internal/pkg/ledger"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+	"github.com/stockholmfootvolley/booking/internal/pkg/payment"
 	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
 	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
 )
 
 type config struct {
-	ServiceAccount string `env:"SERVICE_ACCOUNT,required"`
-	CalendarID     string `env:"CALENDAR_ID,required"`
-	SpreadsheetID  string `env:"SPREADSHEET_ID,required"`
-	ClientID       string `env:"CLIENT_ID,required"`
-	Port           string `env:"PORT" envDefault:"8080"`
-	ProjectID      string `env:"PROJECT_ID,required"`
-	PhoneNumber    string `env:"PHONE_NUMBER" envDefault:"0724675429"`
+	MembersTTL     string   `env:"MEMBERS_TTL" envDefault:"5m"`
+	ClientID       string   `env:"CLIENT_ID"`
+	Port           string   `env:"PORT" envDefault:"8080"`
+	ProjectID      string   `env:"PROJECT_ID"`
+	PhoneNumber    string   `env:"PHONE_NUMBER" envDefault:"0724675429"`
+	SwishQR        string   `env:"SWISH_QR" envDefault:"local"`
+	PublicURL      string   `env:"PUBLIC_URL"`
+	AdminEmails    []string `env:"ADMIN_EMAILS" envSeparator:","`
+	Treasurers     []string `env:"TREASURER_EMAILS" envSeparator:","`
+	Notifier       string   `env:"NOTIFIER" envDefault:"log"`
+	SMTPHost       string   `env:"SMTP_HOST"`
+	SMTPPort       string   `env:"SMTP_PORT" envDefault:"587"`
+	SMTPUsername   string   `env:"SMTP_USERNAME"`
+	SMTPPassword   string   `env:"SMTP_PASSWORD"`
+	SMTPFrom       string   `env:"SMTP_FROM"`
+	StripeKey      string   `env:"STRIPE_KEY"`
+	StripeProduct  string   `env:"STRIPE_PRODUCT_ID"`
+	StripeWebhook  string   `env:"STRIPE_WEBHOOK_SECRET"`
+	Ledger         string   `env:"LEDGER" envDefault:"file"`
+	LedgerPath     string   `env:"LEDGER_PATH" envDefault:"webhook-events.json"`
+	SwishCommerce  string   `env:"SWISH_COMMERCE_URL"`
+	SwishPayee     string   `env:"SWISH_PAYEE_ALIAS"`
+	SwishCert      string   `env:"SWISH_CERT_FILE"`
+	SwishKey       string   `env:"SWISH_KEY_FILE"`
+	SwishCA        string   `env:"SWISH_CA_FILE"`
+	SessionSecret  string   `env:"SESSION_SECRET"`
+	SessionTTL     string   `env:"SESSION_TTL" envDefault:"15m"`
+	RefreshTTL     string   `env:"REFRESH_TTL" envDefault:"720h"`
+	SessionStore   string   `env:"SESSION_STORE" envDefault:"memory"`
+	SessionPath    string   `env:"SESSION_PATH" envDefault:"sessions.db"`
+	ProviderTokens bool     `env:"ACCEPT_PROVIDER_TOKENS" envDefault:"false"`
+	FacebookApp    string   `env:"FACEBOOK_APP_ID"`
+	FacebookSecret string   `env:"FACEBOOK_APP_SECRET"`
+	FacebookGraph  string   `env:"FACEBOOK_GRAPH_URL" envDefault:"https://graph.facebook.com"`
+	FacebookAPI    string   `env:"FACEBOOK_API_VERSION" envDefault:"v14.0"`
+	FacebookScopes []string `env:"FACEBOOK_SCOPES" envSeparator:"," envDefault:"email"`
 }
 
 func main() {
@@ -28,46 +67,195 @@ func main() {
 	if err := env.Parse(&cfg); err != nil {
 		log.Fatalf("%+v\n", err)
 	}
-
-	serviceAccountPlainText, err := base64.RawStdEncoding.DecodeString(cfg.ServiceAccount)
+	storage, err := appconfig.LoadStorage()
 	if err != nil {
-		log.Fatalf("could not parse service account")
+		log.Fatalf("%+v\n", err)
 	}
-	cfg.ServiceAccount = string(serviceAccountPlainText)
 
 	// Creates a client.
 	ctx := context.Background()
-	client, err := logging.NewClient(ctx, cfg.ProjectID)
+	client, logger, err := appconfig.NewLogger(ctx, cfg.ProjectID)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
 	defer client.Close()
 
-	logger := client.Logger(cfg.ProjectID)
+	renderer, err := newQrRenderer(cfg)
 	if err != nil {
-		log.Fatalf("could not start logger")
+		log.Fatalf("could not start swish: %v", err)
 	}
-
-	swish, err := swish.New(cfg.PhoneNumber, logger)
+	commerce, err := newSwishCommerce(cfg)
+	if err != nil {
+		log.Fatalf("could not start swish payment requests: %v", err)
+	}
+	swish, err := swish.New(cfg.PhoneNumber, logger, renderer, commerce)
 	if err != nil {
 		log.Fatalf("could not swish logger")
 	}
 
-	calendarService, err := calendar.New(cfg.ServiceAccount, cfg.CalendarID, logger, swish)
+	paymentService, err := newPaymentService(cfg, logger)
+	if err != nil {
+		log.Fatalf("could not start payment service: %v", err)
+	}
+
+	eventStore, err := storage.NewEventStore(logger)
+	if err != nil {
+		log.Fatalf("could not start event store: %v", err)
+	}
+	calendarService := calendar.New(eventStore, logger, newNotifier(cfg, logger), paymentService)
+
+	members, err := storage.NewMembers(logger)
+	if err != nil {
+		log.Fatalf("could not start spreadsheet service: %v", err)
+	}
+	membersTTL, err := time.ParseDuration(cfg.MembersTTL)
+	if err != nil {
+		log.Fatalf("invalid MEMBERS_TTL: %v", err)
+	}
+	spreadsheetService := spreadsheet.NewDirectory(members, membersTTL, logger)
+	spreadsheetService.Start(ctx)
+
+	webhookLedger, err := newLedger(cfg)
+	if err != nil {
+		log.Fatalf("could not start webhook ledger: %v", err)
+	}
+
+	sessions, err := newSessions(cfg, logger)
 	if err != nil {
-		log.Fatalf("could not start calendar service")
+		log.Fatalf("could not start sessions: %v", err)
 	}
 
-	spreadsheetService, err := spreadsheet.New(cfg.ServiceAccount, cfg.SpreadsheetID, logger)
+	facebookService, err := newFacebook(cfg)
 	if err != nil {
-		log.Fatalf("could not start spreadsheet service")
+		log.Fatalf("could not start facebook login: %v", err)
 	}
 
 	restService := rest.New(
 		calendarService,
 		spreadsheetService,
-		cfg.Port,
-		cfg.ClientID,
+		paymentService,
+		swish,
+		webhookLedger,
+		sessions,
+		facebookService,
+		rest.Config{
+			Port:            cfg.Port,
+			ClientID:        cfg.ClientID,
+			AdminEmails:     cfg.AdminEmails,
+			TreasurerEmails: cfg.Treasurers,
+			PublicURL:       cfg.PublicURL,
+			WebhookSecret:   cfg.StripeWebhook,
+			ProviderTokens:  cfg.ProviderTokens,
+		},
 		logger)
 	restService.Serve()
 }
+
+// newLedger opens the record of processed webhook events. The bolt ledger
+// needs a LEDGER_PATH of its own, apart from BOLT_PATH.
+func newLedger(cfg config) (ledger.API, error) {
+	switch cfg.Ledger {
+	case "file":
+		return ledger.NewFileLedger(cfg.LedgerPath)
+	case "bolt":
+		return ledger.NewBoltLedger(cfg.LedgerPath)
+	default:
+		return nil, fmt.Errorf("unknown ledger %q", cfg.Ledger)
+	}
+}
+
+// newSessions returns nil when SESSION_SECRET is not set, which is only
+// allowed while members may keep sending their login provider tokens.
+func newSessions(cfg config, logger *logging.Logger) (auth.API, error) {
+	if cfg.SessionSecret == "" {
+		if !cfg.ProviderTokens {
+			return nil, errors.New("SESSION_SECRET is required unless ACCEPT_PROVIDER_TOKENS is set")
+		}
+		return nil, nil
+	}
+	accessTTL, err := time.ParseDuration(cfg.SessionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SESSION_TTL: %w", err)
+	}
+	refreshTTL, err := time.ParseDuration(cfg.RefreshTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REFRESH_TTL: %w", err)
+	}
+
+	var store auth.Store
+	switch cfg.SessionStore {
+	case "memory":
+		store = auth.NewMemoryStore()
+	case "bolt":
+		store, err = auth.NewBoltStore(cfg.SessionPath)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown session store %q", cfg.SessionStore)
+	}
+	return auth.New(cfg.SessionSecret, accessTTL, refreshTTL, store, logger)
+}
+
+// newFacebook returns nil when FACEBOOK_APP_ID is not set, which turns
+// Facebook login off.
+func newFacebook(cfg config) (facebook.API, error) {
+	if cfg.FacebookApp == "" {
+		return nil, nil
+	}
+	return facebook.New(facebook.Config{
+		AppID:      cfg.FacebookApp,
+		AppSecret:  cfg.FacebookSecret,
+		GraphURL:   cfg.FacebookGraph,
+		APIVersion: cfg.FacebookAPI,
+		Scopes:     cfg.FacebookScopes,
+	})
+}
+
+func newQrRenderer(cfg config) (swish.Renderer, error) {
+	switch cfg.SwishQR {
+	case "local":
+		return swish.LocalRenderer{}, nil
+	case "remote":
+		return swish.NewRemoteRenderer(), nil
+	default:
+		return nil, fmt.Errorf("unknown swish qr renderer %q", cfg.SwishQR)
+	}
+}
+
+// newSwishCommerce returns nil when SWISH_COMMERCE_URL is not set, which
+// turns Swish payment requests off.
+func newSwishCommerce(cfg config) (*swish.Commerce, error) {
+	if cfg.SwishCommerce == "" {
+		return nil, nil
+	}
+	if cfg.PublicURL == "" {
+		return nil, errors.New("PUBLIC_URL is required with SWISH_COMMERCE_URL, swish calls it back")
+	}
+	return swish.NewCommerce(swish.CommerceConfig{
+		URL:        cfg.SwishCommerce,
+		PayeeAlias: cfg.SwishPayee,
+		CertFile:   cfg.SwishCert,
+		KeyFile:    cfg.SwishKey,
+		CAFile:     cfg.SwishCA,
+	})
+}
+
+func newNotifier(cfg config, logger *logging.Logger) notify.API {
+	if cfg.Notifier == "smtp" {
+		return notify.NewSMTPNotifier(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+	}
+	return notify.NewLogNotifier(logger)
+}
+
+// newPaymentService returns nil when Stripe is not configured, which turns
+// card payments off.
+func newPaymentService(cfg config, logger *logging.Logger) (payment.API, error) {
+	if cfg.StripeKey == "" {
+		return nil, nil
+	}
+	if cfg.StripeProduct == "" || cfg.StripeWebhook == "" {
+		return nil, errors.New("STRIPE_PRODUCT_ID and STRIPE_WEBHOOK_SECRET are required with STRIPE_KEY")
+	}
+	return payment.New(cfg.StripeKey, cfg.StripeProduct, logger), nil
+}
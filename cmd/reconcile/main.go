@@ -0,0 +1,89 @@
+// Command reconcile imports a Swish or bank CSV statement, confirms the
+// payments it can match to sessions and members and prints the report.
+//
+//	reconcile -dry-run statement.csv
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	_ "time/tzdata"
+
+	"github.com/caarlos0/env"
+	appconfig "github.com/stockholmfootvolley/booking/internal/app/config"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+	"github.com/stockholmfootvolley/booking/internal/pkg/reconcile"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+)
+
+type config struct {
+	ProjectID string `env:"PROJECT_ID"`
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the matches without confirming payments")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: reconcile [-dry-run] statement.csv")
+		os.Exit(2)
+	}
+
+	cfg := config{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+	storage, err := appconfig.LoadStorage()
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("could not open statement: %v", err)
+	}
+	defer file.Close()
+
+	transactions, invalid, err := swish.ParseStatement(file)
+	if err != nil {
+		log.Fatalf("could not read statement: %v", err)
+	}
+	for _, line := range invalid {
+		log.Printf("skipping %v", &line)
+	}
+
+	ctx := context.Background()
+	client, logger, err := appconfig.NewLogger(ctx, cfg.ProjectID)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	eventStore, err := storage.NewEventStore(logger)
+	if err != nil {
+		log.Fatalf("could not start event store: %v", err)
+	}
+	calendarService := calendar.New(eventStore, logger, notify.NewLogNotifier(logger), nil)
+
+	members, err := storage.NewMembers(logger)
+	if err != nil {
+		log.Fatalf("could not start spreadsheet service: %v", err)
+	}
+	spreadsheetService := spreadsheet.NewDirectory(members, spreadsheet.DefaultTTL, logger)
+
+	report, err := reconcile.New(calendarService, spreadsheetService, logger).Import(ctx, transactions, *dryRun)
+	if err != nil {
+		log.Fatalf("could not import statement: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("could not print report: %v", err)
+	}
+}
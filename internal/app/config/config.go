@@ -0,0 +1,110 @@
+// Package config reads the settings shared by the server and the command
+// line tools, so they open the same event store and member list.
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/logging"
+	"github.com/caarlos0/env"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar/boltstore"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Storage tells where events and members are kept. The Google settings
+// are only needed for the google store and the sheet members.
+type Storage struct {
+	ServiceAccount string   `env:"SERVICE_ACCOUNT"`
+	CalendarID     string   `env:"CALENDAR_ID"`
+	Store          string   `env:"STORE" envDefault:"google"`
+	BoltPath       string   `env:"BOLT_PATH" envDefault:"booking.db"`
+	Members        string   `env:"MEMBERS" envDefault:"sheet"`
+	MembersPath    string   `env:"MEMBERS_PATH" envDefault:"members.csv"`
+	SpreadsheetID  string   `env:"SPREADSHEET_ID"`
+	SheetRange     string   `env:"SHEET_RANGE" envDefault:"Sheet1!A:Z"`
+	SheetColumns   []string `env:"SHEET_COLUMNS" envSeparator:","`
+}
+
+// LoadStorage reads the storage settings from the environment. The
+// service account is given base64 encoded.
+func LoadStorage() (*Storage, error) {
+	storage := &Storage{}
+	if err := env.Parse(storage); err != nil {
+		return nil, err
+	}
+
+	if storage.Store == "google" || storage.Members == "sheet" {
+		if storage.ServiceAccount == "" {
+			return nil, errors.New("SERVICE_ACCOUNT is required for the google store and the sheet members")
+		}
+		serviceAccount, err := base64.RawStdEncoding.DecodeString(storage.ServiceAccount)
+		if err != nil {
+			return nil, errors.New("could not parse service account")
+		}
+		storage.ServiceAccount = string(serviceAccount)
+	}
+	if storage.Members == "sheet" && storage.SpreadsheetID == "" {
+		return nil, errors.New("SPREADSHEET_ID is required for the sheet members")
+	}
+	return storage, nil
+}
+
+func (s *Storage) NewEventStore(logger *logging.Logger) (calendar.EventStore, error) {
+	switch s.Store {
+	case "google":
+		if s.CalendarID == "" {
+			return nil, errors.New("CALENDAR_ID is required for the google store")
+		}
+		return calendar.NewGoogleStore(s.ServiceAccount, s.CalendarID, logger)
+	case "bolt":
+		return boltstore.New(s.BoltPath)
+	default:
+		return nil, fmt.Errorf("unknown store %q", s.Store)
+	}
+}
+
+// NewMembers opens the member list, the Google sheet or a CSV file with
+// the same columns.
+func (s *Storage) NewMembers(logger *logging.Logger) (spreadsheet.Reader, error) {
+	columns, err := spreadsheet.ParseColumns(s.SheetColumns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHEET_COLUMNS: %w", err)
+	}
+	switch s.Members {
+	case "sheet":
+		schema := spreadsheet.Schema{Range: s.SheetRange, Columns: columns}
+		return spreadsheet.New(s.ServiceAccount, s.SpreadsheetID, schema, logger)
+	case "file":
+		return spreadsheet.NewFile(s.MembersPath, columns, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown members %q", s.Members)
+	}
+}
+
+// NewLogger logs to Cloud Logging in projectID. Without a project the
+// entries are written to stdout as JSON, and nothing is sent to Google.
+func NewLogger(ctx context.Context, projectID string) (*logging.Client, *logging.Logger, error) {
+	if projectID != "" {
+		client, err := logging.NewClient(ctx, projectID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, client.Logger(projectID), nil
+	}
+
+	client, err := logging.NewClient(ctx, "projects/local",
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, client.Logger("booking", logging.RedirectAsJSON(os.Stdout)), nil
+}
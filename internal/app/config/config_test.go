@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+func TestLoadStorageWithoutGoogle(t *testing.T) {
+	t.Setenv("STORE", "bolt")
+	t.Setenv("MEMBERS", "file")
+
+	storage, err := LoadStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.Store != "bolt" || storage.Members != "file" {
+		t.Errorf("got %+v", storage)
+	}
+}
+
+func TestLoadStorageRequiresGoogleSettings(t *testing.T) {
+	account := base64.RawStdEncoding.EncodeToString([]byte(`{"type":"service_account"}`))
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"google store", map[string]string{"STORE": "google", "MEMBERS": "file"}, true},
+		{"sheet members", map[string]string{"STORE": "bolt", "MEMBERS": "sheet", "SPREADSHEET_ID": "sheet"}, true},
+		{"sheet without id", map[string]string{"STORE": "bolt", "MEMBERS": "sheet", "SERVICE_ACCOUNT": account}, true},
+		{"google", map[string]string{"STORE": "google", "MEMBERS": "sheet", "SERVICE_ACCOUNT": account, "SPREADSHEET_ID": "sheet"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{"SERVICE_ACCOUNT", "SPREADSHEET_ID"} {
+				t.Setenv(key, "")
+			}
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			storage, err := LoadStorage()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got %+v, %v, want error %v", storage, err, test.wantErr)
+			}
+			if err == nil && storage.ServiceAccount != `{"type":"service_account"}` {
+				t.Errorf("service account was not decoded: %q", storage.ServiceAccount)
+			}
+		})
+	}
+}
+
+func TestNewLoggerWithoutProject(t *testing.T) {
+	client, logger, err := NewLogger(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger == nil {
+		t.Fatal("no logger")
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,149 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+)
+
+var ErrMissingRole = errors.New("missing role")
+
+// requireRole only lets members with role through, the others get a 403
+// naming the role.
+func (s *Server) requireRole(role model.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInfo := s.GetUserFromContext(c)
+		if model.HasRole(userInfo.User.Roles, role) {
+			c.Next()
+			return
+		}
+		abortWithReason(c, http.StatusForbidden, fmt.Errorf("%w: requires the %s role", ErrMissingRole, role))
+	}
+}
+
+// configuredRoles adds the roles given by email in the configuration, so
+// a deployment has an admin before anyone edits the spreadsheet.
+func (s *Server) configuredRoles(user *spreadsheet.User) {
+	for _, email := range s.adminEmails {
+		if strings.EqualFold(strings.TrimSpace(email), user.Email) {
+			user.Roles = model.AddRole(user.Roles, model.Admin)
+		}
+	}
+	for _, email := range s.treasurerEmails {
+		if strings.EqualFold(strings.TrimSpace(email), user.Email) {
+			user.Roles = model.AddRole(user.Roles, model.Treasurer)
+		}
+	}
+}
+
+func (s *Server) createSession(c *gin.Context) {
+	session := calendar.Session{}
+	if err := c.ShouldBindJSON(&session); err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := s.calendarService.CreateEvent(c, session)
+	if err != nil {
+		s.abortSessionError(c, "could not create event", err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, event)
+}
+
+func (s *Server) editSession(c *gin.Context) {
+	eventID := c.Param("id")
+
+	session := calendar.Session{}
+	if err := c.ShouldBindJSON(&session); err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := s.calendarService.EditEvent(c, eventID, session)
+	if err != nil {
+		s.abortSessionError(c, "could not edit event", err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, event)
+}
+
+func (s *Server) deleteSession(c *gin.Context) {
+	eventID := c.Param("id")
+	force, _ := strconv.ParseBool(c.Query("force"))
+
+	err := s.calendarService.DeleteEvent(c, eventID, force)
+	if err != nil {
+		s.abortSessionError(c, "could not delete event", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type CancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (s *Server) cancelSession(c *gin.Context) {
+	request := CancelRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+
+	cancellation, err := s.calendarService.CancelEvent(c, c.Param("id"), request.Reason)
+	if err != nil {
+		s.abortSessionError(c, "could not cancel event", err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, cancellation)
+}
+
+func (s *Server) abortSessionError(c *gin.Context, message string, err error) {
+	var validationErr *calendar.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		abortWithReason(c, http.StatusBadRequest, err)
+	case errors.Is(err, calendar.ErrEventHasAttendees), errors.Is(err, calendar.ErrAlreadyCancelled):
+		abortWithReason(c, http.StatusConflict, err)
+	case errors.Is(err, calendar.ErrAmbiguousEvent):
+		abortWithEventError(c, err, errors.New(message))
+	default:
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": message,
+				"user":    s.GetUserFromContext(c).User.Email,
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(errorStatus(err), errors.New(message))
+	}
+}
+
+// reloadMembers reads the member spreadsheet again, for changes that
+// should not wait for the cache to expire. The answer lists the rows that
+// could not be read.
+func (s *Server) reloadMembers(c *gin.Context) {
+	report, err := s.spreadsheetService.Reload()
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not reload members",
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusBadGateway, errors.New("could not reload members"))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
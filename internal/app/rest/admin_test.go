@@ -0,0 +1,52 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+)
+
+func TestRequireRole(t *testing.T) {
+	organizer := spreadsheet.User{Name: "Organizer", Email: "organizer@example.com", Roles: []model.Role{model.Member, model.Organizer}}
+	admin := spreadsheet.User{Name: "Admin", Email: "admin@example.com", Roles: []model.Role{model.Member, model.Admin}}
+
+	tests := []struct {
+		name string
+		user spreadsheet.User
+		path string
+		want int
+		role model.Role
+	}{
+		{name: "member on organizer route", user: testMember, path: "/admin/series", want: http.StatusForbidden, role: model.Organizer},
+		{name: "organizer", user: organizer, path: "/admin/series", want: http.StatusOK},
+		{name: "admin has every role", user: admin, path: "/admin/series", want: http.StatusOK},
+		{name: "organizer on admin route", user: organizer, path: "/admin/members/reload", want: http.StatusForbidden, role: model.Admin},
+		{name: "admin", user: admin, path: "/admin/members/reload", want: http.StatusOK},
+		{name: "organizer on treasurer route", user: organizer, path: "/treasurer/claims", want: http.StatusForbidden, role: model.Treasurer},
+	}
+	server := newTestServer(t, nil, nil, Config{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := http.MethodGet
+			if strings.HasSuffix(test.path, "/reload") {
+				method = http.MethodPost
+			}
+
+			response := server.do(method, test.path, server.login(t, test.user), "")
+			if response.Code != test.want {
+				t.Fatalf("got %d, want %d: %s", response.Code, test.want, response.Body)
+			}
+			if test.want != http.StatusForbidden {
+				return
+			}
+			body := map[string]string{}
+			decodeBody(t, response, &body)
+			if !strings.Contains(body["error"], "requires the "+string(test.role)+" role") {
+				t.Errorf("got %q, want the missing role", body["error"])
+			}
+		})
+	}
+}
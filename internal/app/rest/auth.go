@@ -0,0 +1,184 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/auth"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+)
+
+var (
+	ErrSessionsDisabled = errors.New("sessions are not enabled")
+	ErrNotMember        = errors.New("not a member")
+)
+
+// SessionRequest carries the Google ID token or Facebook access token to
+// log in with. The token may also be sent in the authorization header.
+type SessionRequest struct {
+	Token string `json:"token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// login logs a member in with a login provider token and answers with a
+// session of our own.
+func (s *Server) login(c *gin.Context) {
+	if s.sessions == nil {
+		abortWithReason(c, http.StatusServiceUnavailable, ErrSessionsDisabled)
+		return
+	}
+
+	body := SessionRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+	}
+	token := body.Token
+	if token == "" {
+		token = strings.ReplaceAll(c.Request.Header.Get("authorization"), "Bearer ", "")
+	}
+	if token == "" {
+		abortWithReason(c, http.StatusBadRequest, errors.New("missing token"))
+		return
+	}
+
+	userInfo, statusCode, err := s.loginMember(c, token)
+	if err != nil {
+		abortWithReason(c, statusCode, err)
+		return
+	}
+
+	session, err := s.sessions.Issue(c, auth.Identity{
+		Email:   userInfo.User.Email,
+		Name:    userInfo.User.Name,
+		Level:   userInfo.User.Level.String(),
+		Roles:   roleNames(userInfo.User.Roles),
+		Picture: userInfo.Picture,
+	})
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not issue session",
+				"user":    userInfo.User.Email,
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusInternalServerError, errors.New("could not issue session"))
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, session)
+}
+
+// refreshSession rotates the refresh token. The member is looked up again,
+// so level changes show up and former members lose access.
+func (s *Server) refreshSession(c *gin.Context) {
+	if s.sessions == nil {
+		abortWithReason(c, http.StatusServiceUnavailable, ErrSessionsDisabled)
+		return
+	}
+
+	body := RefreshRequest{}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+
+	session, err := s.sessions.Refresh(c, body.RefreshToken, func(identity auth.Identity) (*auth.Identity, error) {
+		member, err := s.findMember(identity.Email)
+		if err != nil {
+			return nil, err
+		}
+		identity.Level = member.Level.String()
+		identity.Roles = roleNames(member.Roles)
+		return &identity, nil
+	})
+	switch {
+	case errors.Is(err, auth.ErrInvalidRefreshToken), errors.Is(err, auth.ErrRefreshTokenReused),
+		errors.Is(err, ErrNotMember):
+		abortWithReason(c, http.StatusUnauthorized, err)
+		return
+	case err != nil:
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not refresh session",
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusInternalServerError, errors.New("could not refresh session"))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, session)
+}
+
+// logout revokes the session of the refresh token. Access tokens already
+// handed out stay valid until they expire.
+func (s *Server) logout(c *gin.Context) {
+	if s.sessions == nil {
+		abortWithReason(c, http.StatusServiceUnavailable, ErrSessionsDisabled)
+		return
+	}
+
+	body := RefreshRequest{}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+
+	err := s.sessions.Revoke(c, body.RefreshToken)
+	if err != nil && !errors.Is(err, auth.ErrInvalidRefreshToken) {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not revoke session",
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusInternalServerError, errors.New("could not revoke session"))
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// findMember looks email up in the member spreadsheet.
+func (s *Server) findMember(email string) (*spreadsheet.User, error) {
+	users, err := s.spreadsheetService.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for index := range users {
+		if strings.EqualFold(users[index].Email, email) {
+			member := users[index]
+			s.configuredRoles(&member)
+			return &member, nil
+		}
+	}
+	return nil, ErrNotMember
+}
+
+func roleNames(roles []model.Role) []string {
+	names := []string{}
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+	return names
+}
+
+// sessionRoles reads the roles of a session token. Roles this server no
+// longer knows are dropped.
+func sessionRoles(names []string) []model.Role {
+	roles := []model.Role{model.Member}
+	for _, name := range names {
+		if role, err := model.ParseRole(name); err == nil {
+			roles = model.AddRole(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/auth"
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook"
+)
+
+// fakeFacebook knows a single token, of testMember.
+type fakeFacebook struct {
+	calls int
+}
+
+func (f *fakeFacebook) Validate(ctx context.Context, token string) (*facebook.User, error) {
+	f.calls++
+	if token != "facebook-token" {
+		return nil, facebook.ErrInvalidToken
+	}
+	return &facebook.User{ID: "42", Name: testMember.Name, Email: testMember.Email}, nil
+}
+
+func newFacebookServer(t *testing.T, cfg Config) (*testServer, *fakeFacebook) {
+	server := newTestServer(t, nil, nil, cfg)
+	provider := &fakeFacebook{}
+	server.facebook = provider
+	return server, provider
+}
+
+func TestProviderTokenOnlyAtLogin(t *testing.T) {
+	server, provider := newFacebookServer(t, Config{})
+
+	response := server.do(http.MethodGet, "/user", "facebook-token", "")
+	if response.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /user with a provider token: got %d, want 401", response.Code)
+	}
+	if provider.calls != 0 {
+		t.Fatalf("a protected request called the login provider %d times", provider.calls)
+	}
+
+	response = server.do(http.MethodPost, "/auth/session", "", `{"token":"facebook-token"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("POST /auth/session: got %d: %s", response.Code, response.Body)
+	}
+	session := auth.Session{}
+	decodeBody(t, response, &session)
+
+	response = server.do(http.MethodGet, "/user", session.AccessToken, "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /user with a session: got %d: %s", response.Code, response.Body)
+	}
+	userInfo := UserInfo{}
+	decodeBody(t, response, &userInfo)
+	if userInfo.User.Email != testMember.Email {
+		t.Errorf("got user %+v, want %s", userInfo.User, testMember.Email)
+	}
+	if provider.calls != 1 {
+		t.Errorf("got %d calls to the login provider, want only the login", provider.calls)
+	}
+}
+
+func TestProviderTokensWhenAllowed(t *testing.T) {
+	server, provider := newFacebookServer(t, Config{ProviderTokens: true})
+
+	response := server.do(http.MethodGet, "/user", "facebook-token", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /user with a provider token: got %d: %s", response.Code, response.Body)
+	}
+	if provider.calls != 1 {
+		t.Errorf("got %d calls to the login provider, want 1", provider.calls)
+	}
+
+	if response := server.do(http.MethodGet, "/user", "unknown-token", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("GET /user with an unknown token: got %d, want 401", response.Code)
+	}
+}
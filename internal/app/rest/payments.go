@@ -2,29 +2,44 @@ package rest
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
+	"time"
 
 	"cloud.google.com/go/logging"
 	"github.com/gin-gonic/gin"
 	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
 	"github.com/stockholmfootvolley/booking/internal/pkg/payment"
-	"github.com/stripe/stripe-go"
-	"github.com/stripe/stripe-go/webhook"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
 )
 
+var (
+	ErrPaymentsDisabled = errors.New("card payments are not enabled")
+	ErrFreeEvent        = errors.New("event is free")
+)
+
+// webhookTolerance is how old a signed webhook may be. Older deliveries
+// are rejected as replays, Stripe signs its retries again.
+const webhookTolerance = 5 * time.Minute
+
 type PaymentLink struct {
 	PaymentLink string `json:"payment_link"`
 }
 
 func (s *Server) webhook(c *gin.Context) {
+	if s.paymentService == nil {
+		abortWithReason(c, http.StatusServiceUnavailable, ErrPaymentsDisabled)
+		return
+	}
+
 	payload, err := ioutil.ReadAll(c.Request.Body)
 	if err != nil {
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
-				"message": "Error reading request body: %v\n",
+				"message": "could not read webhook body",
 				"error":   err,
 			}},
 		)
@@ -32,16 +47,21 @@ func (s *Server) webhook(c *gin.Context) {
 		return
 	}
 
-	event, err := webhook.ConstructEvent(
+	event, err := webhook.ConstructEventWithTolerance(
 		payload,
 		c.Request.Header.Get("Stripe-Signature"),
-		"webhook")
+		s.webhookSecret,
+		webhookTolerance)
 
 	if err != nil {
+		message := "could not parse webhook"
+		if errors.Is(err, webhook.ErrTooOld) {
+			message = "rejected replayed webhook"
+		}
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
-				"message": "could not parse webhook",
+				"message": message,
 				"header":  c.Request.Header.Get("Stripe-Signature"),
 				"error":   err,
 			}},
@@ -50,79 +70,228 @@ func (s *Server) webhook(c *gin.Context) {
 		return
 	}
 
-	checkoutSession := stripe.CheckoutSession{}
-	if checkoutSession.UnmarshalJSON(event.Data.Raw) != nil {
+	claimed, err := s.ledger.Claim(c, event.ID)
+	if err != nil {
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
-				"message": "could not parse webhook",
-				"error":   err,
+				"message":   "could not claim webhook event",
+				"stripe_id": event.ID,
+				"error":     err,
 			}},
 		)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	if !claimed {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Info,
+			Payload: map[string]interface{}{
+				"message":   "ignoring duplicate webhook delivery",
+				"type":      event.Type,
+				"stripe_id": event.ID,
+			}},
+		)
+		c.AbortWithStatus(http.StatusOK)
+		return
+	}
 
-	eventID := checkoutSession.Metadata[payment.MetadataEventName]
-	userEmail := checkoutSession.Metadata[payment.MetadataUserEmail]
-	userName := checkoutSession.Metadata[payment.MetadataUserName]
-
-	user, err := s.spreadsheetService.GetUser(userEmail)
-	if err != nil {
+	var statusCode int
+	switch event.Type {
+	case "checkout.session.completed",
+		"checkout.session.async_payment_succeeded",
+		"checkout.session.async_payment_failed",
+		"checkout.session.expired":
+		statusCode, err = s.handleCheckoutSession(c, event)
+	case "charge.refunded":
+		statusCode, err = s.handleChargeRefunded(c, event)
+	case "payment_intent.payment_failed":
+		statusCode, err = s.handlePaymentFailed(c, event)
+	default:
 		s.logger.Log(logging.Entry{
-			Severity: logging.Error,
+			Severity: logging.Info,
 			Payload: map[string]interface{}{
-				"message": "could not found user on metadata",
-				"user":    user.Email,
-				"error":   err,
+				"message":   "ignoring webhook event",
+				"type":      event.Type,
+				"stripe_id": event.ID,
 			}},
 		)
-		c.AbortWithStatus(http.StatusNotFound)
+		c.AbortWithStatus(http.StatusOK)
 		return
 	}
-	user.Name = userName
 
-	// event seems valid: let's update calendar
-	_, _ = strconv.Atoi(event.GetObjectValue("amount_total"))
-	_, err = s.calendarService.AddAttendeeEvent(c, eventID, &calendar.Payment{
-		Email: userEmail,
-	}, user)
 	if err != nil {
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
-				"message": "could not update event",
-				"user":    user.Email,
-				"error":   err,
+				"message":   "could not handle webhook event",
+				"type":      event.Type,
+				"stripe_id": event.ID,
+				"error":     err,
 			}},
 		)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		s.releaseWebhookEvent(c, event.ID)
+		c.AbortWithStatus(statusCode)
 		return
 	}
 
 	c.AbortWithStatus(http.StatusOK)
 }
 
-func (s *Server) getPaymentLink(c *gin.Context) (*PaymentLink, error) {
-	eventDate := c.Param("date")
-	userInfo := s.GetUserFromContext(c)
+// releaseWebhookEvent lets Stripe retry a delivery that failed.
+func (s *Server) releaseWebhookEvent(c *gin.Context, id string) {
+	if err := s.ledger.Release(c, id); err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message":   "could not release webhook event, retries will be ignored",
+				"stripe_id": id,
+				"error":     err,
+			}},
+		)
+	}
+}
+
+func (s *Server) handleCheckoutSession(c *gin.Context, event stripe.Event) (int, error) {
+	checkoutSession := stripe.CheckoutSession{}
+	if err := checkoutSession.UnmarshalJSON(event.Data.Raw); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	status := calendar.PaymentPending
+	switch {
+	case event.Type == "checkout.session.expired":
+		status = calendar.PaymentExpired
+	case event.Type == "checkout.session.async_payment_failed":
+		status = calendar.PaymentFailed
+	case checkoutSession.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid:
+		status = calendar.PaymentConfirmed
+	}
+
+	update := calendar.PaymentUpdate{
+		Reference: checkoutSession.ID,
+		Method:    calendar.MethodStripe,
+		Status:    status,
+		Amount:    checkoutSession.AmountTotal,
+		Time:      time.Unix(event.Created, 0),
+	}
+	if checkoutSession.PaymentIntent != nil {
+		update.PaymentIntent = checkoutSession.PaymentIntent.ID
+	}
+	return s.recordPayment(c, &checkoutSession, update)
+}
+
+func (s *Server) handleChargeRefunded(c *gin.Context, event stripe.Event) (int, error) {
+	charge := stripe.Charge{}
+	if err := charge.UnmarshalJSON(event.Data.Raw); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if charge.PaymentIntent == nil {
+		return http.StatusBadRequest, errors.New("charge has no payment intent")
+	}
 
-	event, _, err := s.calendarService.GetSingleEvent(c, eventDate, &userInfo.User)
+	checkoutSession, err := s.paymentService.GetCheckoutSession(c, charge.PaymentIntent.ID)
 	if err != nil {
-		return nil, errors.New("could not found event for date " + eventDate)
+		return http.StatusInternalServerError, err
+	}
+
+	// a partial refund leaves the payment below the price, which is
+	// recorded as underpaid
+	status := calendar.PaymentConfirmed
+	if charge.Refunded {
+		status = calendar.PaymentRefunded
+	}
+	return s.recordPayment(c, checkoutSession, calendar.PaymentUpdate{
+		Reference: checkoutSession.ID,
+		Method:    calendar.MethodStripe,
+		Status:    status,
+		Amount:    charge.Amount - charge.AmountRefunded,
+		Time:      time.Unix(event.Created, 0),
+	})
+}
+
+func (s *Server) handlePaymentFailed(c *gin.Context, event stripe.Event) (int, error) {
+	paymentIntent := stripe.PaymentIntent{}
+	if err := paymentIntent.UnmarshalJSON(event.Data.Raw); err != nil {
+		return http.StatusBadRequest, err
 	}
 
-	_, err = s.calendarService.GoogleEventToEvent(event)
+	checkoutSession, err := s.paymentService.GetCheckoutSession(c, paymentIntent.ID)
 	if err != nil {
-		return nil, errors.New("getPayment: could not convert event " + eventDate)
+		return http.StatusInternalServerError, err
 	}
 
-	/*
-		link, err := s.paymentService.CreatePayment(c, int64(newEvent.Price), eventDate, userInfo)
-		if err != nil {
-			return nil, errors.New("getPayment: could not create payment link " + eventDate)
-		}
-	*/
+	return s.recordPayment(c, checkoutSession, calendar.PaymentUpdate{
+		Reference: checkoutSession.ID,
+		Method:    calendar.MethodStripe,
+		Status:    calendar.PaymentFailed,
+		Time:      time.Unix(event.Created, 0),
+	})
+}
+
+// recordPayment updates the event and member named in the metadata of the
+// checkout session, which CreatePayment copied from the payment link.
+func (s *Server) recordPayment(c *gin.Context, checkoutSession *stripe.CheckoutSession, update calendar.PaymentUpdate) (int, error) {
+	eventID := checkoutSession.Metadata[payment.MetadataEventName]
+	userEmail := checkoutSession.Metadata[payment.MetadataUserEmail]
+	userName := checkoutSession.Metadata[payment.MetadataUserName]
+
+	user, err := s.spreadsheetService.GetUser(userEmail)
+	if err != nil {
+		return http.StatusNotFound, fmt.Errorf("could not found user %s on metadata: %w", userEmail, err)
+	}
+	user.Name = userName
+
+	_, err = s.calendarService.RecordPayment(c, eventID, user, update)
+	if err != nil {
+		return errorStatus(err), fmt.Errorf("could not update event %s: %w", eventID, err)
+	}
+	return http.StatusOK, nil
+}
+
+func (s *Server) getPaymentLink(c *gin.Context) {
+	if s.paymentService == nil {
+		abortWithReason(c, http.StatusServiceUnavailable, ErrPaymentsDisabled)
+		return
+	}
+
+	eventID := c.Param("id")
+	userInfo := s.GetUserFromContext(c)
+
+	event, err := s.calendarService.GetEvent(c, eventID)
+	if err != nil {
+		abortWithEventError(c, err, errors.New("getPayment: could not found event "+eventID))
+		return
+	}
+	if event.Cancelled {
+		abortWithReason(c, http.StatusConflict, calendar.ErrEventCancelled)
+		return
+	}
+	if event.Price <= 0 {
+		abortWithReason(c, http.StatusBadRequest, ErrFreeEvent)
+		return
+	}
+	if !event.HasSpotFor(userInfo.User.Email) {
+		abortWithReason(c, http.StatusConflict, calendar.ErrEventFull)
+		return
+	}
+
+	// the event id, not the date, goes to the metadata so the webhook finds
+	// the event even when there are several on that day
+	link, err := s.paymentService.CreatePayment(c, int64(event.Price), event.ID, userInfo.User)
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not create payment link",
+				"event":   event.ID,
+				"user":    userInfo.User.Email,
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusBadGateway, errors.New("getPayment: could not create payment link "+eventID))
+		return
+	}
 
-	return &PaymentLink{""}, nil
+	c.IndentedJSON(http.StatusOK, PaymentLink{PaymentLink: link})
 }
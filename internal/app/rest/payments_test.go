@@ -0,0 +1,366 @@
+package rest
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/payment"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// fakePayments hands out checkout links without calling Stripe. sessions
+// holds the checkout sessions by payment intent.
+type fakePayments struct {
+	links    []string
+	sessions map[string]*stripe.CheckoutSession
+}
+
+func (f *fakePayments) CreatePayment(ctx context.Context, price int64, event string, user spreadsheet.User) (string, error) {
+	link := fmt.Sprintf("https://checkout.example.com/%s/%s/%d", event, user.Email, price)
+	f.links = append(f.links, link)
+	return link, nil
+}
+
+func (f *fakePayments) CreatePrice(ctx context.Context, price int64) (*stripe.Price, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakePayments) GetPrice(ctx context.Context, price int64) (*stripe.Price, error) {
+	return nil, payment.ErrNotFound
+}
+
+func (f *fakePayments) GetCheckoutSession(ctx context.Context, paymentIntentID string) (*stripe.CheckoutSession, error) {
+	checkoutSession, ok := f.sessions[paymentIntentID]
+	if !ok {
+		return nil, payment.ErrNotFound
+	}
+	return checkoutSession, nil
+}
+
+func (f *fakePayments) Refund(ctx context.Context, paymentIntentID string, amount int64, idempotencyKey string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+// postWebhook delivers a Stripe event signed with secret.
+func (s *testServer) postWebhook(t *testing.T, event map[string]interface{}, secret string) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	signature := hex.EncodeToString(webhook.ComputeSignature(now, payload, secret))
+
+	request := httptest.NewRequest(http.MethodPost, "/stripe/webhook", strings.NewReader(string(payload)))
+	request.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), signature))
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func stripeEvent(id string, eventType string, object map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      id,
+		"object":  "event",
+		"type":    eventType,
+		"created": time.Now().Unix(),
+		"data":    map[string]interface{}{"object": object},
+	}
+}
+
+// checkoutSession is the session "cs_test" of user paying for eventID with
+// the payment intent "pi_test".
+func checkoutSession(eventID string, user spreadsheet.User, paymentStatus string, amount int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             "cs_test",
+		"object":         "checkout.session",
+		"payment_status": paymentStatus,
+		"amount_total":   amount,
+		"payment_intent": "pi_test",
+		"metadata": map[string]string{
+			payment.MetadataEventName: eventID,
+			payment.MetadataUserEmail: user.Email,
+			payment.MetadataUserName:  user.Name,
+		},
+	}
+}
+
+// storedSession is the session of checkoutSession as GetCheckoutSession
+// returns it.
+func storedSession(eventID string, user spreadsheet.User) *stripe.CheckoutSession {
+	return &stripe.CheckoutSession{
+		ID: "cs_test",
+		Metadata: map[string]string{
+			payment.MetadataEventName: eventID,
+			payment.MetadataUserEmail: user.Email,
+			payment.MetadataUserName:  user.Name,
+		},
+	}
+}
+
+func checkoutCompleted(eventID string, user spreadsheet.User, amount int64) map[string]interface{} {
+	return stripeEvent("evt_completed", "checkout.session.completed", checkoutSession(eventID, user, "paid", amount))
+}
+
+func newPaymentServer(t *testing.T) (*testServer, *fakePayments) {
+	payments := &fakePayments{}
+	return newTestServer(t, payments, nil, Config{WebhookSecret: testWebhookSecret}), payments
+}
+
+func TestGetPaymentLink(t *testing.T) {
+	server, payments := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+
+	response := server.do(http.MethodGet, "/event/"+eventID+"/payment", server.login(t, testMember), "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body)
+	}
+	link := PaymentLink{}
+	decodeBody(t, response, &link)
+	if len(payments.links) != 1 || link.PaymentLink != payments.links[0] {
+		t.Errorf("got link %q, created %v", link.PaymentLink, payments.links)
+	}
+}
+
+func TestGetPaymentLinkFreeEvent(t *testing.T) {
+	server, payments := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{MaxParticipants: 10})
+
+	response := server.do(http.MethodGet, "/event/"+eventID+"/payment", server.login(t, testMember), "")
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want 400: %s", response.Code, response.Body)
+	}
+	if len(payments.links) != 0 {
+		t.Errorf("created links %v for a free event", payments.links)
+	}
+}
+
+func TestGetPaymentLinkFullEvent(t *testing.T) {
+	server, payments := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{
+		Price:           100,
+		MaxParticipants: 1,
+		Attendees:       []calendar.Attendee{{Name: "Other", Email: "other@example.com"}},
+	})
+
+	response := server.do(http.MethodGet, "/event/"+eventID+"/payment", server.login(t, testMember), "")
+	if response.Code != http.StatusConflict {
+		t.Fatalf("got %d, want 409: %s", response.Code, response.Body)
+	}
+	if len(payments.links) != 0 {
+		t.Errorf("created links %v for a full event", payments.links)
+	}
+}
+
+func TestWebhookCheckoutCompleted(t *testing.T) {
+	server, _ := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+
+	response := server.postWebhook(t, checkoutCompleted(eventID, testMember, 10000), testWebhookSecret)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body)
+	}
+
+	response = server.do(http.MethodGet, "/event/"+eventID, server.login(t, testMember), "")
+	event := &calendar.Event{}
+	decodeBody(t, response, event)
+	if event.PaymentStatus != calendar.PaymentConfirmed {
+		t.Errorf("got payment status %q, want %q", event.PaymentStatus, calendar.PaymentConfirmed)
+	}
+	if len(event.Payments) != 1 || event.Payments[0].Reference != "cs_test" || event.Payments[0].Amount != 10000 {
+		t.Errorf("got payments %+v, want the checkout session", event.Payments)
+	}
+	if !isAttending(event, testMember.Email) {
+		t.Errorf("paying member is not attending %+v", event.Attendees)
+	}
+}
+
+func TestWebhookBadSignature(t *testing.T) {
+	server, _ := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+
+	response := server.postWebhook(t, checkoutCompleted(eventID, testMember, 10000), "whsec_other")
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want 400", response.Code)
+	}
+
+	response = server.do(http.MethodGet, "/event/"+eventID, server.login(t, testMember), "")
+	event := &calendar.Event{}
+	decodeBody(t, response, event)
+	if len(event.Payments) != 0 || isAttending(event, testMember.Email) {
+		t.Errorf("unsigned webhook changed the event: payments %+v, attendees %+v", event.Payments, event.Attendees)
+	}
+}
+
+func TestWebhookCheckoutSessionFailed(t *testing.T) {
+	tests := []struct {
+		eventType string
+		want      string
+	}{
+		{eventType: "checkout.session.expired", want: calendar.PaymentExpired},
+		{eventType: "checkout.session.async_payment_failed", want: calendar.PaymentFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.eventType, func(t *testing.T) {
+			server, _ := newPaymentServer(t)
+			eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+			token := server.login(t, testMember)
+
+			// a bank transfer is pending until the money arrives
+			started := stripeEvent("evt_started", "checkout.session.completed", checkoutSession(eventID, testMember, "unpaid", 10000))
+			if response := server.postWebhook(t, started, testWebhookSecret); response.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", response.Code, response.Body)
+			}
+			event := server.eventOf(t, eventID, token)
+			if event.PaymentStatus != calendar.PaymentPending || isAttending(event, testMember.Email) {
+				t.Fatalf("got payment status %q, attendees %+v, want a pending payment holding no spot", event.PaymentStatus, event.Attendees)
+			}
+
+			failed := stripeEvent("evt_failed", test.eventType, checkoutSession(eventID, testMember, "unpaid", 10000))
+			if response := server.postWebhook(t, failed, testWebhookSecret); response.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", response.Code, response.Body)
+			}
+			event = server.eventOf(t, eventID, token)
+			if event.PaymentStatus != test.want {
+				t.Errorf("got payment status %q, want %q", event.PaymentStatus, test.want)
+			}
+			if len(event.Payments) != 1 || isAttending(event, testMember.Email) {
+				t.Errorf("got payments %+v, attendees %+v", event.Payments, event.Attendees)
+			}
+		})
+	}
+}
+
+func TestWebhookExpiredWithoutPayment(t *testing.T) {
+	server, _ := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+
+	expired := stripeEvent("evt_expired", "checkout.session.expired", checkoutSession(eventID, testMember, "unpaid", 10000))
+	if response := server.postWebhook(t, expired, testWebhookSecret); response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body)
+	}
+	event := server.eventOf(t, eventID, server.login(t, testMember))
+	if len(event.Payments) != 0 {
+		t.Errorf("got payments %+v for a session that was never paid", event.Payments)
+	}
+}
+
+func TestWebhookChargeRefunded(t *testing.T) {
+	tests := []struct {
+		name           string
+		amountRefunded int64
+		refunded       bool
+		wantStatus     string
+		wantAmount     int64
+	}{
+		{name: "partial refund", amountRefunded: 5000, wantStatus: calendar.PaymentUnderpaid, wantAmount: 5000},
+		{name: "full refund", amountRefunded: 10000, refunded: true, wantStatus: calendar.PaymentRefunded, wantAmount: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, payments := newPaymentServer(t)
+			eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+			token := server.login(t, testMember)
+
+			if response := server.postWebhook(t, checkoutCompleted(eventID, testMember, 10000), testWebhookSecret); response.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", response.Code, response.Body)
+			}
+
+			payments.sessions = map[string]*stripe.CheckoutSession{"pi_test": storedSession(eventID, testMember)}
+
+			refunded := stripeEvent("evt_refunded", "charge.refunded", map[string]interface{}{
+				"id":              "ch_test",
+				"object":          "charge",
+				"amount":          10000,
+				"amount_refunded": test.amountRefunded,
+				"refunded":        test.refunded,
+				"payment_intent":  "pi_test",
+			})
+			if response := server.postWebhook(t, refunded, testWebhookSecret); response.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", response.Code, response.Body)
+			}
+
+			event := server.eventOf(t, eventID, token)
+			if event.PaymentStatus != test.wantStatus {
+				t.Errorf("got payment status %q, want %q", event.PaymentStatus, test.wantStatus)
+			}
+			if len(event.Payments) != 1 || event.Payments[0].Reference != "cs_test" || event.Payments[0].Amount != test.wantAmount {
+				t.Errorf("got payments %+v, want %d öre left on cs_test", event.Payments, test.wantAmount)
+			}
+		})
+	}
+}
+
+func TestWebhookPaymentIntentFailed(t *testing.T) {
+	server, payments := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+	token := server.login(t, testMember)
+
+	started := stripeEvent("evt_started", "checkout.session.completed", checkoutSession(eventID, testMember, "unpaid", 10000))
+	if response := server.postWebhook(t, started, testWebhookSecret); response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body)
+	}
+
+	failed := stripeEvent("evt_intent_failed", "payment_intent.payment_failed", map[string]interface{}{
+		"id":     "pi_test",
+		"object": "payment_intent",
+	})
+
+	// without the checkout session the webhook is retried later
+	if response := server.postWebhook(t, failed, testWebhookSecret); response.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want 500: %s", response.Code, response.Body)
+	}
+
+	payments.sessions = map[string]*stripe.CheckoutSession{"pi_test": storedSession(eventID, testMember)}
+
+	if response := server.postWebhook(t, failed, testWebhookSecret); response.Code != http.StatusOK {
+		t.Fatalf("retry: got %d: %s", response.Code, response.Body)
+	}
+	event := server.eventOf(t, eventID, token)
+	if event.PaymentStatus != calendar.PaymentFailed || isAttending(event, testMember.Email) {
+		t.Errorf("got payment status %q, attendees %+v, want a failed payment", event.PaymentStatus, event.Attendees)
+	}
+}
+
+func TestWebhookDuplicateDelivery(t *testing.T) {
+	server, _ := newPaymentServer(t)
+	eventID := server.addEvent(t, calendar.Description{Price: 100, MaxParticipants: 10})
+	token := server.login(t, testMember)
+
+	completed := checkoutCompleted(eventID, testMember, 5000)
+	for i := 0; i < 2; i++ {
+		if response := server.postWebhook(t, completed, testWebhookSecret); response.Code != http.StatusOK {
+			t.Fatalf("delivery %d: got %d: %s", i+1, response.Code, response.Body)
+		}
+	}
+
+	// a delivery with a known id is not handled again, even if its content
+	// changed
+	if response := server.postWebhook(t, checkoutCompleted(eventID, testMember, 10000), testWebhookSecret); response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body)
+	}
+
+	event := server.eventOf(t, eventID, token)
+	if event.PaymentStatus != calendar.PaymentUnderpaid {
+		t.Errorf("got payment status %q, want %q", event.PaymentStatus, calendar.PaymentUnderpaid)
+	}
+	if len(event.Payments) != 1 || event.Payments[0].Amount != 5000 {
+		t.Errorf("got payments %+v, want the first delivery only", event.Payments)
+	}
+	if len(event.Attendees) != 1 {
+		t.Errorf("got attendees %+v, want the member once", event.Attendees)
+	}
+}
@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/reconcile"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+)
+
+type ImportResponse struct {
+	*reconcile.Report
+	InvalidLines []swish.StatementError `json:"invalid_lines"`
+}
+
+// importStatement reconciles a Swish or bank CSV statement, sent as the
+// body or as the file field of a form. With dry_run nothing is recorded.
+func (s *Server) importStatement(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	var statement io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			abortWithReason(c, http.StatusBadRequest, err)
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			abortWithReason(c, http.StatusBadRequest, err)
+			return
+		}
+		defer file.Close()
+		statement = file
+	}
+
+	transactions, invalid, err := swish.ParseStatement(statement)
+	if err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := s.reconciler.Import(c, transactions, dryRun)
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not import statement",
+				"user":    s.GetUserFromContext(c).User.Email,
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(errorStatus(err), errors.New("could not import statement"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, ImportResponse{Report: report, InvalidLines: invalid})
+}
@@ -3,15 +3,24 @@ package rest
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/logging"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/auth"
 	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook"
+	"github.com/stockholmfootvolley/booking/internal/pkg/ledger"
 	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/payment"
+	"github.com/stockholmfootvolley/booking/internal/pkg/reconcile"
 	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
 )
 
 var (
@@ -19,12 +28,46 @@ var (
 	ErrMarshalJSON = errors.New("could not marshal json")
 )
 
+const (
+	DefaultEventsLimit = 10
+	MaxEventsLimit     = 250
+)
+
 type Server struct {
 	calendarService    calendar.API
 	spreadsheetService spreadsheet.API
+	paymentService     payment.API
+	swishService       swish.API
+	reconciler         reconcile.API
+	ledger             ledger.API
+	sessions           auth.API
+	facebook           facebook.API
 	port               string
 	logger             *logging.Logger
 	clientID           string
+	adminEmails        []string
+	treasurerEmails    []string
+	webhookSecret      string
+	publicURL          string
+	providerTokens     bool
+}
+
+// Config holds the settings of the REST server.
+type Config struct {
+	Port     string
+	ClientID string
+	// AdminEmails and TreasurerEmails give roles on top of the roles column
+	// of the member spreadsheet.
+	AdminEmails     []string
+	TreasurerEmails []string
+	// WebhookSecret is the signing secret of the Stripe webhook endpoint.
+	WebhookSecret string
+	// PublicURL is where clients reach the server, links in responses are
+	// relative without it.
+	PublicURL string
+	// ProviderTokens lets clients that do not use sessions yet send their
+	// Google or Facebook token on every request.
+	ProviderTokens bool
 }
 
 type API interface {
@@ -34,128 +77,273 @@ type API interface {
 func New(
 	calendarService calendar.API,
 	spreadsheetService spreadsheet.API,
-	port string, clientID string,
+	paymentService payment.API,
+	swishService swish.API,
+	webhookLedger ledger.API,
+	sessions auth.API,
+	facebookService facebook.API,
+	cfg Config,
 	logger *logging.Logger) API {
 
 	return &Server{
 		calendarService:    calendarService,
 		spreadsheetService: spreadsheetService,
-		port:               port,
+		paymentService:     paymentService,
+		swishService:       swishService,
+		reconciler:         reconcile.New(calendarService, spreadsheetService, logger),
+		ledger:             webhookLedger,
+		sessions:           sessions,
+		facebook:           facebookService,
+		port:               cfg.Port,
 		logger:             logger,
-		clientID:           clientID,
+		clientID:           cfg.ClientID,
+		adminEmails:        cfg.AdminEmails,
+		treasurerEmails:    cfg.TreasurerEmails,
+		webhookSecret:      cfg.WebhookSecret,
+		publicURL:          strings.TrimSuffix(cfg.PublicURL, "/"),
+		providerTokens:     cfg.ProviderTokens,
 	}
 }
 
 func (s *Server) getEvents(c *gin.Context) {
-	events, err := s.calendarService.GetEvents(c)
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	events, err := s.calendarService.GetEvents(c, *filter)
 
 	if err != nil {
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
 				"message": "could not retrieve events",
+				"error":   err,
 			}},
 		)
+		if errors.Is(err, calendar.ErrInvalidPageToken) {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
 		c.AbortWithError(http.StatusInternalServerError, ErrGetEvents)
 		return
 	}
 
+	for _, event := range events.Events {
+		s.present(c, event)
+	}
 	c.IndentedJSON(http.StatusOK, events)
 }
 
+// parseEventFilter reads the from, to, level, limit and page_token query
+// parameters of GET /events. Without from, only upcoming events are listed.
+func parseEventFilter(c *gin.Context) (*calendar.EventFilter, error) {
+	filter := &calendar.EventFilter{
+		EventQuery: calendar.EventQuery{
+			From:      time.Now(),
+			Limit:     DefaultEventsLimit,
+			PageToken: c.Query("page_token"),
+		},
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseQueryTime(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %w", err)
+		}
+		filter.From = t
+	}
+
+	if to := c.Query("to"); to != "" {
+		t, err := parseQueryTime(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %w", err)
+		}
+		if !t.After(filter.From) {
+			return nil, errors.New("invalid to: must be after from")
+		}
+		filter.To = t
+	}
+
+	if level := c.Query("level"); level != "" {
+		parsed, err := model.ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		filter.Level = parsed.String()
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > MaxEventsLimit {
+			return nil, fmt.Errorf("invalid limit: must be between 1 and %d", MaxEventsLimit)
+		}
+		filter.Limit = parsed
+	}
+
+	return filter, nil
+}
+
+// parseQueryTime accepts either a RFC3339 timestamp or a plain date.
+func parseQueryTime(value string) (time.Time, error) {
+	if t := model.TimeParse(value); t != nil {
+		return *t, nil
+	}
+	return time.Parse(model.DateLayout, value)
+}
+
 func (s *Server) getEvent(c *gin.Context) {
-	eventDate := c.Param("date")
-	userInfo := s.GetUserFromContext(c)
-	event, _, err := s.calendarService.GetSingleEvent(c, eventDate, &userInfo.User)
+	eventID := c.Param("id")
+	userInfo, _ := LookupUser(c)
+	newEvent, err := s.calendarService.GetEvent(c, eventID)
 	if err != nil {
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
 				"message": "could not retrieve event",
 				"user":    userInfo.User.Email,
+				"error":   err,
 			}},
 		)
-		c.AbortWithError(
-			http.StatusInternalServerError,
-			errors.New("could not found event for date "+eventDate))
+		abortWithEventError(c, err, errors.New("could not found event "+eventID))
 		return
 	}
 
-	newEvent, err := s.calendarService.GoogleEventToEvent(event)
-	if err != nil {
-		s.logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "could not convert to google event",
-				"user":    userInfo.User.Email,
-			}},
-		)
-		c.AbortWithError(
-			http.StatusInternalServerError,
-			errors.New("getEvent: could not convert event "+eventDate))
-		return
-	}
+	s.present(c, newEvent)
 	c.IndentedJSON(http.StatusOK, newEvent)
 }
 
+// present fills in the fields of event that depend on the caller: the link
+// to the Swish QR code, with the member name in the payment message, and
+// what is about the member. Only the payments of the caller are left.
+func (s *Server) present(c *gin.Context, event *calendar.Event) {
+	userInfo, isMember := LookupUser(c)
+	if isMember {
+		event.WaitlistPosition = event.WaitlistPositionOf(userInfo.User.Email)
+		event.PaymentStatus = event.PaymentStatusOf(userInfo.User.Email)
+	}
+	event.Payments = event.Payments.Of(userInfo.User.Email)
+	if event.Price > 0 && !event.Cancelled {
+		event.QrCodeURL = s.qrCodeURL(event.ID, userInfo.User.Name)
+	}
+}
+
 func (s *Server) addPresence(c *gin.Context) {
-	eventDate := c.Param("date")
+	eventID := c.Param("id")
 
 	userInfo := s.GetUserFromContext(c)
 	var payment *calendar.Payment
 
 	newEvent, err := s.calendarService.AddAttendeeEvent(c,
-		eventDate,
+		eventID,
 		payment,
 		&userInfo.User)
 
 	if err != nil {
-		c.AbortWithError(
-			http.StatusInternalServerError,
-			errors.New("addPresence: could not convert event "+eventDate))
+		abortWithEventError(c, err,
+			errors.New("addPresence: could not convert event "+eventID))
 		return
 	}
+	s.present(c, newEvent)
 	c.IndentedJSON(http.StatusCreated, newEvent)
 }
 
 func (s *Server) removePresence(c *gin.Context) {
-	eventDate := c.Param("date")
+	eventID := c.Param("id")
 	userInfo := s.GetUserFromContext(c)
-	newEvent, err := s.calendarService.RemoveAttendee(c, eventDate, &userInfo.User)
+	newEvent, err := s.calendarService.RemoveAttendee(c, eventID, &userInfo.User)
 
 	if err != nil {
-		c.AbortWithError(
-			http.StatusInternalServerError,
-			errors.New("removePresence: could not convert event "+eventDate))
+		abortWithEventError(c, err,
+			errors.New("removePresence: could not convert event "+eventID))
 		return
 	}
+	s.present(c, newEvent)
 	c.IndentedJSON(http.StatusAccepted, newEvent)
 }
 
 func (s *Server) changePayment(c *gin.Context) {
-	eventDate := c.Param("date")
+	eventID := c.Param("id")
 
 	userInfo := s.GetUserFromContext(c)
 
 	event, err := s.calendarService.UpdateEvent(c,
-		eventDate,
+		eventID,
 		&userInfo.User)
 
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		if err == calendar.ErrCannotRemovePayment {
-			statusCode = http.StatusExpectationFailed
-		}
-
-		c.AbortWithError(
-			statusCode,
-			errors.New("addPresence: could not convert event "+eventDate))
+		abortWithEventError(c, err,
+			errors.New("addPresence: could not convert event "+eventID))
 		return
 	}
+	s.present(c, event)
 	c.IndentedJSON(http.StatusCreated, event)
 }
 
+// abortWithReason aborts the request with err in a JSON body, for errors
+// the client can act on.
+func abortWithReason(c *gin.Context, statusCode int, err error) {
+	c.AbortWithStatusJSON(statusCode, gin.H{"error": err.Error()})
+}
+
+// abortWithEventError aborts with the status matching err. Errors the
+// member can act on, like a missed deadline, are explained in the body.
+func abortWithEventError(c *gin.Context, err error, fallback error) {
+	var deadlineErr *calendar.DeadlineError
+	var ambiguousErr *calendar.AmbiguousEventError
+	switch {
+	case errors.As(err, &deadlineErr):
+		c.AbortWithStatusJSON(http.StatusExpectationFailed, gin.H{
+			"error":       deadlineErr.Error(),
+			"deadline":    deadlineErr.Deadline,
+			"deadline_at": deadlineErr.At,
+		})
+	case errors.As(err, &ambiguousErr):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error":     ambiguousErr.Error(),
+			"date":      ambiguousErr.Date,
+			"event_ids": ambiguousErr.EventIDs,
+		})
+	case errors.Is(err, calendar.ErrEventCancelled), errors.Is(err, calendar.ErrConcurrentUpdate),
+		errors.Is(err, calendar.ErrPaymentConfirmed), errors.Is(err, calendar.ErrClaimRejected),
+		errors.Is(err, calendar.ErrNotAttending):
+		abortWithReason(c, errorStatus(err), err)
+	default:
+		c.AbortWithError(errorStatus(err), fallback)
+	}
+}
+
+// errorStatus maps errors from the calendar service to the status code
+// returned to the client.
+func errorStatus(err error) int {
+	var descErr *calendar.DescriptionError
+	switch {
+	case errors.As(err, &descErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, calendar.ErrCannotRemovePayment):
+		return http.StatusExpectationFailed
+	case errors.Is(err, calendar.ErrConcurrentUpdate):
+		return http.StatusConflict
+	case errors.Is(err, calendar.ErrEventNotFound), errors.Is(err, calendar.ErrSeriesNotFound),
+		errors.Is(err, calendar.ErrPaymentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, calendar.ErrAmbiguousEvent):
+		return http.StatusConflict
+	case errors.Is(err, calendar.ErrEventCancelled), errors.Is(err, calendar.ErrAlreadyCancelled),
+		errors.Is(err, calendar.ErrPaymentConfirmed), errors.Is(err, calendar.ErrEventFull),
+		errors.Is(err, calendar.ErrClaimRejected), errors.Is(err, calendar.ErrNotAttending):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *Server) Serve() {
+	s.routes().Run("0.0.0.0:" + s.port)
+}
+
+func (s *Server) routes() *gin.Engine {
 	router := gin.Default()
 
 	// allow cors and authorization flow
@@ -164,69 +352,157 @@ func (s *Server) Serve() {
 	config.AllowCredentials = true
 	config.AddAllowHeaders("authorization")
 	router.Use(cors.New(config))
-	router.Use(s.addParsedToken())
-
-	// and endpoints
-	router.GET("/user", s.getUser)
-
-	router.GET("/events", s.getEvents)
-	router.PUT("/event/:date", s.changePayment)
-	router.GET("/event/:date", s.getEvent)
-	router.POST("/event/:date", s.addPresence)
-	router.DELETE("/event/:date", s.removePresence)
 
-	router.Run("0.0.0.0:" + s.port)
+	// public endpoints: a token is optional and only personalizes the answer
+	public := router.Group("/", s.addOptionalToken())
+	public.GET("/events", s.getEvents)
+	public.GET("/event/:id", s.getEvent)
+	public.GET("/event/:id/swish-qr", s.getSwishQr)
+
+	// stripe signs its webhook calls and swish callbacks are checked against
+	// the swish api, they carry no member token
+	router.POST("/stripe/webhook", s.webhook)
+	router.POST("/swish/callback", s.swishCallback)
+
+	// sessions are created from login provider tokens
+	sessions := router.Group("/auth")
+	sessions.POST("/session", s.login)
+	sessions.POST("/refresh", s.refreshSession)
+	sessions.POST("/logout", s.logout)
+
+	// and member endpoints
+	members := router.Group("/", s.addParsedToken())
+	members.GET("/user", s.getUser)
+	members.PUT("/event/:id", s.changePayment)
+	members.POST("/event/:id", s.addPresence)
+	members.DELETE("/event/:id", s.removePresence)
+	members.GET("/event/:id/payment", s.getPaymentLink)
+	members.POST("/event/:id/swish", s.createSwishRequest)
+	members.GET("/event/:id/swish/:request", s.getSwishRequest)
+
+	// organizers plan the sessions, admins have every role
+	admin := members.Group("/admin")
+	organizers := admin.Group("", s.requireRole(model.Organizer))
+	organizers.POST("/events", s.createSession)
+	organizers.PUT("/events/:id", s.editSession)
+	organizers.DELETE("/events/:id", s.deleteSession)
+	organizers.POST("/events/:id/cancel", s.cancelSession)
+	organizers.GET("/series", s.listSeries)
+	organizers.POST("/series", s.createSeries)
+	organizers.GET("/series/:id", s.getSeries)
+	organizers.PUT("/series/:id", s.editSeries)
+	organizers.DELETE("/series/:id", s.deleteSeries)
+	admin.POST("/payments/import", s.requireRole(model.Treasurer), s.importStatement)
+	admin.POST("/members/reload", s.requireRole(model.Admin), s.reloadMembers)
+
+	treasurer := members.Group("/treasurer", s.requireRole(model.Treasurer))
+	treasurer.GET("/claims", s.listClaims)
+	treasurer.POST("/claims/review", s.reviewClaims)
+
+	return router
 }
 
 func (s *Server) addParsedToken() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.URL.Path != "/user" && c.Request.Method == http.MethodGet {
-			c.Next()
-			return
-		}
-
 		token := c.Request.Header.Get("authorization")
 		if token == "" {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		token = strings.ReplaceAll(token, "Bearer ", "")
-		payload, err := s.ValidateToken(c, token)
+		userInfo, statusCode, err := s.parseToken(c, token)
 		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			c.AbortWithError(statusCode, err)
 			return
 		}
 
-		users, err := s.spreadsheetService.GetUsers()
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
+		c.Set(model.User, *userInfo)
+		c.Next()
+	}
+}
+
+// addOptionalToken identifies the caller when a token is sent, but lets
+// anonymous and invalid requests through without a user in the context.
+func (s *Server) addOptionalToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Request.Header.Get("authorization")
+		if token == "" {
+			c.Next()
 			return
 		}
 
-		for _, user := range users {
-			if strings.EqualFold(user.Email, payload.Email) {
-				user.Name = payload.Name
-				user.Email = payload.Email
-				c.Set(model.User, UserInfo{
-					User: spreadsheet.User{
-						Name:  payload.Name,
-						Email: payload.Email,
-						Level: user.Level,
-					},
-					Picture: payload.Picture,
-				})
-				c.Next()
-				return
-			}
+		userInfo, _, err := s.parseToken(c, token)
+		if err == nil {
+			c.Set(model.User, *userInfo)
 		}
+		c.Next()
+	}
+}
 
-		c.AbortWithError(http.StatusUnauthorized, errors.New("not a member"))
+// parseToken checks session tokens locally. Login provider tokens are
+// traded for a session at POST /auth/session; with providerTokens they are
+// still accepted here, at the price of a call to the provider and to the
+// member spreadsheet on every request.
+func (s *Server) parseToken(ctx context.Context, token string) (*UserInfo, int, error) {
+	token = strings.ReplaceAll(token, "Bearer ", "")
+	if s.sessions != nil {
+		identity, err := s.sessions.Verify(token)
+		if err == nil {
+			return &UserInfo{
+				User: spreadsheet.User{
+					Name:  identity.Name,
+					Email: identity.Email,
+					Level: model.StringToLevel(identity.Level),
+					Roles: sessionRoles(identity.Roles),
+				},
+				Picture: identity.Picture,
+			}, http.StatusOK, nil
+		}
+		if !errors.Is(err, auth.ErrNotSessionToken) || !s.providerTokens {
+			return nil, http.StatusUnauthorized, err
+		}
 	}
+	if !s.providerTokens {
+		return nil, http.StatusServiceUnavailable, ErrSessionsDisabled
+	}
+	return s.loginMember(ctx, token)
 }
 
-func (s *Server) GetUserFromContext(ctx context.Context) UserInfo {
+// loginMember validates a login provider token and finds its member.
+func (s *Server) loginMember(ctx context.Context, token string) (*UserInfo, int, error) {
+	payload, err := s.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	member, err := s.findMember(payload.Email)
+	if errors.Is(err, ErrNotMember) {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &UserInfo{
+		User: spreadsheet.User{
+			Name:  payload.Name,
+			Email: payload.Email,
+			Level: member.Level,
+			Roles: member.Roles,
+		},
+		Picture: payload.Picture,
+	}, http.StatusOK, nil
+}
+
+// LookupUser returns the caller on endpoints where authentication is
+// optional.
+func LookupUser(ctx context.Context) (UserInfo, bool) {
 	userInfo, ok := ctx.Value(model.User).(UserInfo)
+	return userInfo, ok
+}
+
+func (s *Server) GetUserFromContext(ctx context.Context) UserInfo {
+	userInfo, ok := LookupUser(ctx)
 	if !ok {
 		s.logger.Log(logging.Entry{
 			Severity: logging.Error,
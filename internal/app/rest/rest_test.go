@@ -0,0 +1,281 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/auth"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar/boltstore"
+	"github.com/stockholmfootvolley/booking/internal/pkg/ledger"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+	"github.com/stockholmfootvolley/booking/internal/pkg/payment"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const testSecret = "a session secret of at least 32 bytes"
+
+var (
+	testMember = spreadsheet.User{Name: "Member", Email: "member@example.com"}
+	// testDecliner declines Swish payment requests, their name puts
+	// fakeswish.DeclineWord in the payment message
+	testDecliner = spreadsheet.User{Name: "Decline", Email: "decline@example.com"}
+)
+
+// memberSheet is a member spreadsheet that never changes.
+type memberSheet []spreadsheet.User
+
+func (m memberSheet) GetUsers() ([]spreadsheet.User, error) {
+	return m, nil
+}
+
+func (m memberSheet) GetUser(email string) (*spreadsheet.User, error) {
+	for _, user := range m {
+		if user.Email == email {
+			user := user
+			return &user, nil
+		}
+	}
+	return nil, spreadsheet.ErrUserNotFound
+}
+
+func (m memberSheet) Reload() (*spreadsheet.Report, error) {
+	return &spreadsheet.Report{Members: len(m)}, nil
+}
+
+// testServer runs the REST server on a bolt event store, without any
+// Google service.
+type testServer struct {
+	*Server
+	store    *boltstore.Store
+	router   http.Handler
+	sessions auth.API
+}
+
+func newTestServer(t *testing.T, paymentService payment.API, swishService swish.API, cfg Config) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	logger := testLogger(t)
+
+	store, err := boltstore.New(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	webhookLedger, err := ledger.NewFileLedger(filepath.Join(t.TempDir(), "webhook-events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := auth.New(testSecret, 0, 0, auth.NewMemoryStore(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refunder calendar.Refunder
+	if paymentService != nil {
+		refunder = paymentService
+	}
+	server := New(
+		calendar.New(store, logger, notify.NewLogNotifier(logger), refunder),
+		memberSheet{testMember, testDecliner},
+		paymentService,
+		swishService,
+		webhookLedger,
+		sessions,
+		nil,
+		cfg,
+		logger).(*Server)
+
+	return &testServer{
+		Server:   server,
+		store:    store,
+		router:   server.routes(),
+		sessions: sessions,
+	}
+}
+
+// testLogger logs nowhere. Entries are buffered and never delivered.
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	client, err := logging.NewClient(context.Background(), "projects/test",
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client.Logger("test")
+}
+
+// addEvent stores a session a week from now.
+func (s *testServer) addEvent(t *testing.T, description calendar.Description) string {
+	t.Helper()
+	description.Version = calendar.CurrentDescriptionVersion
+	start := time.Now().Add(7 * 24 * time.Hour)
+	event, err := s.store.CreateEvent(context.Background(), &calendar.StoredEvent{
+		Summary:     "Training",
+		Start:       start,
+		End:         start.Add(2 * time.Hour),
+		Description: description.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event.ID
+}
+
+// login returns an access token of user, with the roles of user.
+func (s *testServer) login(t *testing.T, user spreadsheet.User) string {
+	t.Helper()
+	session, err := s.sessions.Issue(context.Background(), auth.Identity{
+		Email: user.Email,
+		Name:  user.Name,
+		Level: user.Level.String(),
+		Roles: roleNames(user.Roles),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.AccessToken
+}
+
+func (s *testServer) do(method string, path string, token string, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		request.Header.Set("authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func decodeBody(t *testing.T, recorder *httptest.ResponseRecorder, value interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(recorder.Body.Bytes(), value); err != nil {
+		t.Fatalf("could not decode %q: %v", recorder.Body.String(), err)
+	}
+}
+
+func isAttending(event *calendar.Event, email string) bool {
+	for _, attendee := range event.Attendees {
+		if attendee.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSignUpOnBoltStore(t *testing.T) {
+	server := newTestServer(t, nil, nil, Config{})
+	eventID := server.addEvent(t, calendar.Description{MaxParticipants: 10})
+	token := server.login(t, testMember)
+
+	response := server.do(http.MethodGet, "/events", "", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /events: got %d: %s", response.Code, response.Body)
+	}
+	page := calendar.EventPage{}
+	decodeBody(t, response, &page)
+	if len(page.Events) != 1 || page.Events[0].ID != eventID {
+		t.Fatalf("GET /events: got %+v, want event %s", page.Events, eventID)
+	}
+
+	if response := server.do(http.MethodPost, "/event/"+eventID, "", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous sign-up: got %d, want 401", response.Code)
+	}
+
+	response = server.do(http.MethodPost, "/event/"+eventID, token, "")
+	if response.Code != http.StatusCreated {
+		t.Fatalf("POST /event: got %d: %s", response.Code, response.Body)
+	}
+	event := &calendar.Event{}
+	decodeBody(t, response, event)
+	if !isAttending(event, testMember.Email) {
+		t.Fatalf("POST /event: member is not attending %+v", event.Attendees)
+	}
+
+	response = server.do(http.MethodGet, "/event/"+eventID, "", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /event: got %d: %s", response.Code, response.Body)
+	}
+	event = &calendar.Event{}
+	decodeBody(t, response, event)
+	if !isAttending(event, testMember.Email) {
+		t.Fatalf("GET /event: sign-up was not stored, attendees %+v", event.Attendees)
+	}
+
+	response = server.do(http.MethodDelete, "/event/"+eventID, token, "")
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("DELETE /event: got %d: %s", response.Code, response.Body)
+	}
+	event = &calendar.Event{}
+	decodeBody(t, response, event)
+	if isAttending(event, testMember.Email) {
+		t.Errorf("DELETE /event: member is still attending %+v", event.Attendees)
+	}
+
+	if response := server.do(http.MethodGet, "/event/missing", "", ""); response.Code != http.StatusNotFound {
+		t.Errorf("GET missing event: got %d, want 404", response.Code)
+	}
+}
+
+func TestEventByAmbiguousDate(t *testing.T) {
+	server := newTestServer(t, nil, nil, Config{})
+	description := calendar.Description{Version: calendar.CurrentDescriptionVersion}
+	ids := []string{}
+	for _, hour := range []int{10, 18} {
+		start := time.Date(2030, 1, 10, hour, 0, 0, 0, time.UTC)
+		event, err := server.store.CreateEvent(context.Background(), &calendar.StoredEvent{
+			Summary:     "Training",
+			Start:       start,
+			End:         start.Add(2 * time.Hour),
+			Description: description.String(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, event.ID)
+	}
+
+	response := server.do(http.MethodGet, "/event/2030-01-10", "", "")
+	if response.Code != http.StatusConflict {
+		t.Fatalf("got %d: %s, want 409", response.Code, response.Body)
+	}
+	body := struct {
+		Date     string   `json:"date"`
+		EventIDs []string `json:"event_ids"`
+	}{}
+	decodeBody(t, response, &body)
+	sort.Strings(ids)
+	sort.Strings(body.EventIDs)
+	if body.Date != "2030-01-10" || !reflect.DeepEqual(body.EventIDs, ids) {
+		t.Errorf("got %+v, want the events %v", body, ids)
+	}
+
+	token := server.login(t, testMember)
+	if response := server.do(http.MethodPost, "/event/2030-01-10", token, ""); response.Code != http.StatusConflict {
+		t.Errorf("sign-up by date: got %d: %s, want 409", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,70 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+)
+
+func (s *Server) listSeries(c *gin.Context) {
+	series, err := s.calendarService.ListSeries(c)
+	if err != nil {
+		s.abortSessionError(c, "could not list series", err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, series)
+}
+
+func (s *Server) getSeries(c *gin.Context) {
+	series, err := s.calendarService.GetSeries(c, c.Param("id"))
+	if err != nil {
+		s.abortSessionError(c, "could not get series", err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, series)
+}
+
+// createSeries stores a new series and generates its events for the season.
+func (s *Server) createSeries(c *gin.Context) {
+	series := calendar.Series{}
+	if err := c.ShouldBindJSON(&series); err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+	series.ID = ""
+
+	result, err := s.calendarService.SaveSeries(c, series)
+	if err != nil {
+		s.abortSessionError(c, "could not create series", err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, result)
+}
+
+// editSeries updates a series and propagates the change to its future
+// events that nobody signed up for yet.
+func (s *Server) editSeries(c *gin.Context) {
+	series := calendar.Series{}
+	if err := c.ShouldBindJSON(&series); err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+	series.ID = c.Param("id")
+
+	result, err := s.calendarService.SaveSeries(c, series)
+	if err != nil {
+		s.abortSessionError(c, "could not edit series", err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, result)
+}
+
+func (s *Server) deleteSeries(c *gin.Context) {
+	result, err := s.calendarService.DeleteSeries(c, c.Param("id"))
+	if err != nil {
+		s.abortSessionError(c, "could not delete series", err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, result)
+}
@@ -0,0 +1,359 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+)
+
+// qrCacheControl lets browsers keep QR codes for an hour. The ETag changes
+// with the price, so a stale image is revalidated after that.
+const qrCacheControl = "public, max-age=3600"
+
+var contentTypes = map[swish.Format]string{
+	swish.PNG: "image/png",
+	swish.SVG: "image/svg+xml",
+}
+
+// qrCodeURL links to the QR code of the event. Images are loaded without
+// the member token, so the name for the payment message goes in the URL.
+func (s *Server) qrCodeURL(eventID string, name string) string {
+	link := s.publicURL + "/event/" + url.PathEscape(eventID) + "/swish-qr"
+	if name != "" {
+		link += "?" + url.Values{"name": {name}}.Encode()
+	}
+	return link
+}
+
+// paymentMessage tells the treasurer which session and member a Swish
+// payment is for.
+func paymentMessage(event *calendar.Event, name string) string {
+	parts := []string{event.Level, event.Date.Format(model.DateLayout), strings.TrimSpace(name)}
+	message := []string{}
+	for _, part := range parts {
+		if part != "" {
+			message = append(message, part)
+		}
+	}
+	return strings.Join(message, " ")
+}
+
+// getSwishQr serves the QR code of the Swish payment for an event as an
+// image, with the size and format query parameters.
+func (s *Server) getSwishQr(c *gin.Context) {
+	eventID := c.Param("id")
+
+	format, err := swish.ParseFormat(c.Query("format"))
+	if err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+	size := swish.DefaultSize
+	if value := c.Query("size"); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil || size < swish.MinSize || size > swish.MaxSize {
+			abortWithReason(c, http.StatusBadRequest,
+				fmt.Errorf("invalid size: must be between %d and %d", swish.MinSize, swish.MaxSize))
+			return
+		}
+	}
+
+	event, err := s.calendarService.GetEvent(c, eventID)
+	if err != nil {
+		abortWithEventError(c, err, errors.New("could not found event "+eventID))
+		return
+	}
+	if event.Cancelled {
+		abortWithReason(c, http.StatusConflict, calendar.ErrEventCancelled)
+		return
+	}
+	if event.Price <= 0 {
+		abortWithReason(c, http.StatusBadRequest, ErrFreeEvent)
+		return
+	}
+
+	name := c.Query("name")
+	if userInfo, isMember := LookupUser(c); isMember {
+		name = userInfo.User.Name
+	}
+	message := paymentMessage(event, name)
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(fmt.Sprintf("%d;%s;%s;%d", event.Price, message, format, size))))
+	c.Header("Cache-Control", qrCacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	image, err := s.swishService.QrCode(event.Price, message, format, size)
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not generate qr code",
+				"event":   eventID,
+				"error":   err,
+			}},
+		)
+		c.Header("Cache-Control", "no-store")
+		c.AbortWithError(http.StatusInternalServerError, errors.New("could not generate qr code"))
+		return
+	}
+
+	c.Data(http.StatusOK, contentTypes[format], image)
+}
+
+// SwishRequest is the optional body of POST /event/:id/swish. With a
+// payer alias the request pops up in the Swish app of that phone,
+// otherwise the answer carries a token to open the app on this device.
+type SwishRequest struct {
+	PayerAlias string `json:"payer_alias"`
+}
+
+type SwishPaymentRequest struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Token  string `json:"token,omitempty"`
+}
+
+// swishStatuses maps payment request statuses to payment statuses.
+var swishStatuses = map[string]string{
+	swish.StatusCreated:   calendar.PaymentPending,
+	swish.StatusPaid:      calendar.PaymentConfirmed,
+	swish.StatusDeclined:  calendar.PaymentFailed,
+	swish.StatusError:     calendar.PaymentFailed,
+	swish.StatusCancelled: calendar.PaymentFailed,
+}
+
+func (s *Server) swishCallbackURL(eventID string) string {
+	return s.publicURL + "/swish/callback?" + url.Values{"event": {eventID}}.Encode()
+}
+
+// createSwishRequest sends a Swish payment request for the event to the
+// member and records it as a pending payment.
+func (s *Server) createSwishRequest(c *gin.Context) {
+	eventID := c.Param("id")
+	userInfo := s.GetUserFromContext(c)
+
+	body := SwishRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+	}
+
+	event, err := s.calendarService.GetEvent(c, eventID)
+	if err != nil {
+		abortWithEventError(c, err, errors.New("could not found event "+eventID))
+		return
+	}
+	if event.Cancelled {
+		abortWithReason(c, http.StatusConflict, calendar.ErrEventCancelled)
+		return
+	}
+	if event.Price <= 0 {
+		abortWithReason(c, http.StatusBadRequest, ErrFreeEvent)
+		return
+	}
+	if !event.HasSpotFor(userInfo.User.Email) {
+		abortWithReason(c, http.StatusConflict, calendar.ErrEventFull)
+		return
+	}
+
+	request, err := s.swishService.CreatePaymentRequest(c, event.Price,
+		paymentMessage(event, userInfo.User.Name), body.PayerAlias, s.swishCallbackURL(event.ID))
+	if errors.Is(err, swish.ErrCommerceDisabled) {
+		abortWithReason(c, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not create swish payment request",
+				"event":   event.ID,
+				"user":    userInfo.User.Email,
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusBadGateway, errors.New("could not create swish payment request"))
+		return
+	}
+
+	_, err = s.calendarService.RecordPayment(c, event.ID, &userInfo.User, calendar.PaymentUpdate{
+		Reference: request.ID,
+		Method:    calendar.MethodSwish,
+		Status:    calendar.PaymentPending,
+		Amount:    int64(request.Amount),
+		Time:      time.Now(),
+	})
+	if err != nil {
+		abortWithEventError(c, err, errors.New("could not record swish payment request"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, SwishPaymentRequest{
+		ID:     request.ID,
+		Status: request.Status,
+		Token:  request.Token,
+	})
+}
+
+// swishCallback is called by Swish when a payment request changes. The
+// body is not signed, so the status is read back from Swish instead.
+func (s *Server) swishCallback(c *gin.Context) {
+	eventID := c.Query("event")
+	callback := swish.PaymentRequest{}
+	if err := c.BindJSON(&callback); err != nil {
+		return
+	}
+
+	request, err := s.syncSwishRequest(c, eventID, callback.ID, s.swishPayer(c, eventID, callback.ID))
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not handle swish callback",
+				"event":   eventID,
+				"request": callback.ID,
+				"error":   err,
+			}},
+		)
+		// swish retries callbacks that are not answered with 200
+		c.AbortWithStatus(swishErrorStatus(err))
+		return
+	}
+
+	s.logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message": "swish payment request updated",
+			"event":   eventID,
+			"request": request.ID,
+			"status":  request.Status,
+		}},
+	)
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// getSwishRequest lets the member poll a payment request, for when the
+// callback is late or never comes.
+func (s *Server) getSwishRequest(c *gin.Context) {
+	eventID := c.Param("id")
+	requestID := c.Param("request")
+	userInfo := s.GetUserFromContext(c)
+
+	event, err := s.calendarService.GetEvent(c, eventID)
+	if err != nil {
+		abortWithEventError(c, err, errors.New("could not found event "+eventID))
+		return
+	}
+	owned := false
+	for _, payment := range event.Payments {
+		if payment.Reference == requestID && strings.EqualFold(payment.Email, userInfo.User.Email) {
+			owned = true
+		}
+	}
+	if !owned {
+		abortWithReason(c, http.StatusNotFound, calendar.ErrPaymentNotFound)
+		return
+	}
+
+	request, err := s.syncSwishRequest(c, event.ID, requestID, &userInfo.User)
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not get swish payment request",
+				"event":   event.ID,
+				"request": requestID,
+				"error":   err,
+			}},
+		)
+		abortWithReason(c, swishErrorStatus(err), err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, SwishPaymentRequest{
+		ID:     request.ID,
+		Status: request.Status,
+	})
+}
+
+// swishPayer finds the member who asked for a payment request, to sign
+// them up once it is paid. It returns nil when the member is unknown.
+func (s *Server) swishPayer(c *gin.Context, eventID string, requestID string) *spreadsheet.User {
+	event, err := s.calendarService.GetEvent(c, eventID)
+	if err != nil {
+		return nil
+	}
+	for _, payment := range event.Payments {
+		if payment.Reference != requestID {
+			continue
+		}
+		payer, err := s.spreadsheetService.GetUser(payment.Email)
+		if err != nil {
+			return nil
+		}
+		return payer
+	}
+	return nil
+}
+
+// syncSwishRequest records the status Swish reports for a payment request
+// made by payer.
+func (s *Server) syncSwishRequest(c *gin.Context, eventID string, requestID string, payer *spreadsheet.User) (*swish.PaymentRequest, error) {
+	if eventID == "" {
+		return nil, calendar.ErrEventNotFound
+	}
+
+	request, err := s.swishService.GetPaymentRequest(c, requestID)
+	if err != nil {
+		return nil, err
+	}
+	status, known := swishStatuses[request.Status]
+	if !known {
+		return nil, fmt.Errorf("unknown swish status %q", request.Status)
+	}
+
+	paid, err := time.Parse(time.RFC3339, request.DatePaid)
+	if err != nil {
+		paid = time.Now()
+	}
+	_, err = s.calendarService.RecordPayment(c, eventID, payer, calendar.PaymentUpdate{
+		Reference: request.ID,
+		Method:    calendar.MethodSwish,
+		Status:    status,
+		Amount:    int64(request.Amount),
+		Time:      paid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func swishErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, swish.ErrCommerceDisabled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, swish.ErrInvalidPaymentRequestID):
+		return http.StatusBadRequest
+	case errors.Is(err, swish.ErrPaymentRequestNotFound):
+		return http.StatusNotFound
+	}
+	return errorStatus(err)
+}
@@ -0,0 +1,181 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish/fakeswish"
+)
+
+// newSwishServer runs the REST server against fakeswish, which settles
+// payment requests after delay. Without callbacks, Swish calls back to an
+// address nobody listens on and members have to poll.
+func newSwishServer(t *testing.T, delay time.Duration, callbacks bool) *testServer {
+	t.Helper()
+	swishServer := httptest.NewServer(fakeswish.New(delay))
+	t.Cleanup(swishServer.Close)
+
+	swishService, err := swish.New("0701234567", testLogger(t), swish.LocalRenderer{}, &swish.Commerce{
+		URL:        swishServer.URL,
+		PayeeAlias: "1231181189",
+		HTTPClient: swishServer.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t, nil, swishService, Config{})
+	if callbacks {
+		restServer := httptest.NewServer(server.router)
+		t.Cleanup(restServer.Close)
+		server.publicURL = restServer.URL
+	} else {
+		server.publicURL = "http://127.0.0.1:1"
+	}
+	return server
+}
+
+func (s *testServer) createSwishRequest(t *testing.T, eventID string, token string) SwishPaymentRequest {
+	t.Helper()
+	response := s.do(http.MethodPost, "/event/"+eventID+"/swish", token, "")
+	if response.Code != http.StatusCreated {
+		t.Fatalf("POST /event/:id/swish: got %d: %s", response.Code, response.Body)
+	}
+	request := SwishPaymentRequest{}
+	decodeBody(t, response, &request)
+	if request.Status != swish.StatusCreated || request.Token == "" {
+		t.Fatalf("got request %+v, want a created request with a token", request)
+	}
+	return request
+}
+
+// eventOf returns the event as member sees it.
+func (s *testServer) eventOf(t *testing.T, eventID string, token string) *calendar.Event {
+	t.Helper()
+	response := s.do(http.MethodGet, "/event/"+eventID, token, "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /event: got %d: %s", response.Code, response.Body)
+	}
+	event := &calendar.Event{}
+	decodeBody(t, response, event)
+	return event
+}
+
+// waitForPayment waits until the payment of the member is no longer
+// pending and returns the event.
+func (s *testServer) waitForPayment(t *testing.T, eventID string, token string) *calendar.Event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		event := s.eventOf(t, eventID, token)
+		if event.PaymentStatus != calendar.PaymentPending {
+			return event
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("payment is still pending, swish never called back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pollSwishRequest polls the payment request until Swish settled it.
+func (s *testServer) pollSwishRequest(t *testing.T, eventID string, requestID string, token string) SwishPaymentRequest {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response := s.do(http.MethodGet, "/event/"+eventID+"/swish/"+requestID, token, "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET /event/:id/swish/:request: got %d: %s", response.Code, response.Body)
+		}
+		request := SwishPaymentRequest{}
+		decodeBody(t, response, &request)
+		if request.Status != swish.StatusCreated {
+			return request
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("payment request is still created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func paidEvent() calendar.Description {
+	return calendar.Description{Price: 100, MaxParticipants: 10}
+}
+
+func TestSwishRequestPaidByCallback(t *testing.T) {
+	server := newSwishServer(t, 50*time.Millisecond, true)
+	eventID := server.addEvent(t, paidEvent())
+	token := server.login(t, testMember)
+
+	request := server.createSwishRequest(t, eventID, token)
+
+	event := server.eventOf(t, eventID, token)
+	if event.PaymentStatus != calendar.PaymentPending || isAttending(event, testMember.Email) {
+		t.Fatalf("before paying: got status %q and attendees %+v, want a pending payment and no sign-up",
+			event.PaymentStatus, event.Attendees)
+	}
+
+	event = server.waitForPayment(t, eventID, token)
+	if event.PaymentStatus != calendar.PaymentConfirmed {
+		t.Fatalf("got payment status %q, want %q", event.PaymentStatus, calendar.PaymentConfirmed)
+	}
+	if len(event.Payments) != 1 || event.Payments[0].Reference != request.ID {
+		t.Errorf("got payments %+v, want request %s", event.Payments, request.ID)
+	}
+	if !isAttending(event, testMember.Email) {
+		t.Errorf("paying member is not attending %+v", event.Attendees)
+	}
+}
+
+func TestSwishRequestPaidByPolling(t *testing.T) {
+	server := newSwishServer(t, 50*time.Millisecond, false)
+	eventID := server.addEvent(t, paidEvent())
+	token := server.login(t, testMember)
+
+	request := server.createSwishRequest(t, eventID, token)
+	polled := server.pollSwishRequest(t, eventID, request.ID, token)
+	if polled.Status != swish.StatusPaid {
+		t.Fatalf("got request status %q, want %q", polled.Status, swish.StatusPaid)
+	}
+
+	event := server.eventOf(t, eventID, token)
+	if event.PaymentStatus != calendar.PaymentConfirmed || !isAttending(event, testMember.Email) {
+		t.Errorf("got status %q and attendees %+v, want a confirmed payment and a sign-up",
+			event.PaymentStatus, event.Attendees)
+	}
+}
+
+func TestSwishRequestDeclined(t *testing.T) {
+	server := newSwishServer(t, 50*time.Millisecond, true)
+	eventID := server.addEvent(t, paidEvent())
+	token := server.login(t, testDecliner)
+
+	server.createSwishRequest(t, eventID, token)
+
+	event := server.waitForPayment(t, eventID, token)
+	if event.PaymentStatus != calendar.PaymentFailed {
+		t.Fatalf("got payment status %q, want %q", event.PaymentStatus, calendar.PaymentFailed)
+	}
+	if isAttending(event, testDecliner.Email) {
+		t.Errorf("member who declined is attending %+v", event.Attendees)
+	}
+}
+
+func TestSwishRequestOfOtherMember(t *testing.T) {
+	server := newSwishServer(t, time.Hour, false)
+	eventID := server.addEvent(t, paidEvent())
+
+	request := server.createSwishRequest(t, eventID, server.login(t, testMember))
+
+	other := spreadsheet.User{Name: "Other", Email: "other@example.com"}
+	response := server.do(http.MethodGet, "/event/"+eventID+"/swish/"+request.ID, server.login(t, other), "")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("got %d, want 404 for the request of another member", response.Code)
+	}
+}
@@ -2,40 +2,31 @@ package rest
 
 import (
 	"context"
-	"encoding/json"
-	"net/http"
-	"net/url"
+	"errors"
 	"strings"
 
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook"
 	"google.golang.org/api/idtoken"
 )
 
+var ErrGoogleDisabled = errors.New("google login is not enabled")
+
 type UserToken struct {
 	Name    string
 	Email   string
 	Picture string
 }
 
-type FacebookResponse struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Picture struct {
-		Data struct {
-			Height       int    `json:"height"`
-			IsSilhouette bool   `json:"is_silhouette"`
-			URL          string `json:"url"`
-			Width        int    `json:"width"`
-		} `json:"data"`
-	} `json:"picture"`
-}
-
 func (s *Server) ValidateToken(ctx context.Context, token string) (*UserToken, error) {
 
 	splitByPoint := strings.Split(token, ".")
 
 	// seems to be a jwt, let's go to google
 	if len(splitByPoint) == 3 {
+		// without a client id google would accept tokens of any app
+		if s.clientID == "" {
+			return nil, ErrGoogleDisabled
+		}
 		payload, err := idtoken.Validate(ctx, token, s.clientID)
 		if err != nil {
 			return nil, err
@@ -45,42 +36,20 @@ func (s *Server) ValidateToken(ctx context.Context, token string) (*UserToken, e
 			Name:    getTokenName(payload),
 			Picture: getTokenPicture(payload),
 		}, nil
-
-	} else {
-		return DebugFacebookToken(token)
 	}
-}
 
-func DebugFacebookToken(token string) (*UserToken, error) {
-	graphURL := url.URL{
-		Scheme: "https",
-		Host:   "graph.facebook.com",
-		Path:   "v14.0/me",
+	if s.facebook == nil {
+		return nil, facebook.ErrDisabled
 	}
-
-	q := graphURL.Query()
-	q.Add("fields", "id,name,email,picture")
-	q.Add("access_token", token)
-	graphURL.RawQuery = q.Encode()
-
-	resp, err := http.Get(graphURL.String())
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-	fbResponse := FacebookResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&fbResponse)
-	if err != nil || resp.StatusCode != http.StatusOK {
+	user, err := s.facebook.Validate(ctx, token)
+	if err != nil {
 		return nil, err
 	}
-
 	return &UserToken{
-		Name:    fbResponse.Name,
-		Email:   fbResponse.Email,
-		Picture: fbResponse.Picture.Data.URL,
+		Name:    user.Name,
+		Email:   user.Email,
+		Picture: user.Picture,
 	}, nil
-
 }
 
 func getTokenName(payload *idtoken.Payload) string {
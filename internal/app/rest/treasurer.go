@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+)
+
+// DefaultClaimsWindow is how far back claims are listed without from.
+const DefaultClaimsWindow = 90 * 24 * time.Hour
+
+type ReviewRequest struct {
+	Reviews []calendar.ClaimReview `json:"reviews"`
+}
+
+// listClaims returns the payments members claim to have made, for events
+// between the from and to query parameters.
+func (s *Server) listClaims(c *gin.Context) {
+	query := calendar.EventQuery{From: time.Now().Add(-DefaultClaimsWindow)}
+	if from := c.Query("from"); from != "" {
+		t, err := parseQueryTime(from)
+		if err != nil {
+			abortWithReason(c, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
+			return
+		}
+		query.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseQueryTime(to)
+		if err != nil {
+			abortWithReason(c, http.StatusBadRequest, fmt.Errorf("invalid to: %w", err))
+			return
+		}
+		query.To = t
+	}
+
+	claims, err := s.calendarService.ListClaims(c, query)
+	if err != nil {
+		s.logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not list claims",
+				"error":   err,
+			}},
+		)
+		c.AbortWithError(http.StatusInternalServerError, errors.New("could not list claims"))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, claims)
+}
+
+// reviewClaims confirms or rejects claims in bulk. Every review gets its
+// own result, so one failing claim does not fail the others.
+func (s *Server) reviewClaims(c *gin.Context) {
+	request := ReviewRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithReason(c, http.StatusBadRequest, err)
+		return
+	}
+	if len(request.Reviews) == 0 {
+		abortWithReason(c, http.StatusBadRequest, errors.New("no reviews"))
+		return
+	}
+
+	results := s.calendarService.ReviewClaims(c, request.Reviews)
+	s.logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message":   "reviewed claims",
+			"treasurer": s.GetUserFromContext(c).User.Email,
+			"results":   results,
+		}},
+	)
+	c.IndentedJSON(http.StatusOK, results)
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+	// MinSecretLength is the shortest signing secret accepted, in bytes.
+	MinSecretLength = 32
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means a refresh token was presented twice,
+	// likely because it was stolen. Its whole session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+)
+
+// Identity is the member a session belongs to.
+type Identity struct {
+	Email   string   `json:"email"`
+	Name    string   `json:"name"`
+	Level   string   `json:"level"`
+	Roles   []string `json:"roles,omitempty"`
+	Picture string   `json:"picture,omitempty"`
+}
+
+// Session is handed to the client after login and on every refresh.
+type Session struct {
+	AccessToken      string    `json:"access_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// API issues short-lived access tokens, checked without network calls,
+// and refresh tokens that are rotated on every use.
+type API interface {
+	Issue(ctx context.Context, identity Identity) (*Session, error)
+	Verify(accessToken string) (*Identity, error)
+	// Refresh trades a refresh token for a new session. update is given
+	// the identity of the session and returns the one to issue, so member
+	// changes are picked up; its errors are returned as they are.
+	Refresh(ctx context.Context, refreshToken string, update func(Identity) (*Identity, error)) (*Session, error)
+	// Revoke ends the session of the refresh token.
+	Revoke(ctx context.Context, refreshToken string) error
+}
+
+type Client struct {
+	Secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	Store      Store
+	Logger     *logging.Logger
+}
+
+func New(secret string, accessTTL time.Duration, refreshTTL time.Duration, store Store, logger *logging.Logger) (*Client, error) {
+	if len(secret) < MinSecretLength {
+		return nil, errors.New("session secret is too short")
+	}
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+	return &Client{
+		Secret:     []byte(secret),
+		AccessTTL:  accessTTL,
+		RefreshTTL: refreshTTL,
+		Store:      store,
+		Logger:     logger,
+	}, nil
+}
+
+func (c *Client) Issue(ctx context.Context, identity Identity) (*Session, error) {
+	family, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return c.issue(ctx, identity, family)
+}
+
+func (c *Client) issue(ctx context.Context, identity Identity, family string) (*Session, error) {
+	now := time.Now()
+	session := &Session{
+		ExpiresAt:        now.Add(c.AccessTTL),
+		RefreshExpiresAt: now.Add(c.RefreshTTL),
+	}
+
+	var err error
+	session.AccessToken, err = encodeToken(c.Secret, identity, now, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	session.RefreshToken, err = randomToken()
+	if err != nil {
+		return nil, err
+	}
+	err = c.Store.Save(ctx, tokenID(session.RefreshToken), RefreshToken{
+		Family:    family,
+		Identity:  identity,
+		ExpiresAt: session.RefreshExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (c *Client) Verify(accessToken string) (*Identity, error) {
+	return decodeToken(c.Secret, accessToken, time.Now())
+}
+
+func (c *Client) Refresh(ctx context.Context, refreshToken string, update func(Identity) (*Identity, error)) (*Session, error) {
+	id := tokenID(refreshToken)
+	stored, err := c.Store.Get(ctx, id)
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Used {
+		return nil, c.revokeReused(ctx, stored)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	identity, err := update(stored.Identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// two requests racing with the same token count as reuse too
+	first, err := c.Store.Use(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, c.revokeReused(ctx, stored)
+	}
+
+	return c.issue(ctx, *identity, stored.Family)
+}
+
+func (c *Client) revokeReused(ctx context.Context, stored *RefreshToken) error {
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Warning,
+		Payload: map[string]interface{}{
+			"message": "refresh token reused, revoking session",
+			"user":    stored.Identity.Email,
+		}},
+	)
+	if err := c.Store.RevokeFamily(ctx, stored.Family); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func (c *Client) Revoke(ctx context.Context, refreshToken string) error {
+	stored, err := c.Store.Get(ctx, tokenID(refreshToken))
+	if errors.Is(err, ErrTokenNotFound) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	return c.Store.RevokeFamily(ctx, stored.Family)
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// tokenID is what the store keeps instead of the refresh token, so a
+// leaked store holds no usable tokens.
+func tokenID(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const testSecret = "a session secret of at least 32 bytes"
+
+var testIdentity = Identity{Email: "member@example.com", Name: "Member", Level: "BASIC", Roles: []string{"member"}}
+
+// testLogger logs nowhere. Entries are buffered and never delivered.
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	client, err := logging.NewClient(context.Background(), "projects/test",
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client.Logger("test")
+}
+
+// testStores runs test against every refresh token store.
+func testStores(t *testing.T, test func(t *testing.T, client *Client)) {
+	logger := testLogger(t)
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"bolt": func(t *testing.T) Store {
+			store, err := NewBoltStore(filepath.Join(t.TempDir(), "sessions.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			client, err := New(testSecret, time.Minute, time.Hour, newStore(t), logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test(t, client)
+		})
+	}
+}
+
+func keepIdentity(identity Identity) (*Identity, error) {
+	return &identity, nil
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	token, err := encodeToken([]byte(testSecret), testIdentity, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := decodeToken([]byte(testSecret), token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Email != testIdentity.Email || identity.Level != testIdentity.Level || len(identity.Roles) != 1 {
+		t.Errorf("got %+v, want %+v", identity, testIdentity)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	secret := []byte(testSecret)
+	token, err := encodeToken(secret, testIdentity, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	// the same claims with another level, under the original signature
+	forged, err := encodeToken(secret, Identity{Email: testIdentity.Email, Level: "ADVANCED"}, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedParts := strings.Split(forged, ".")
+
+	otherSecret, err := encodeToken([]byte("another secret of at least 32 bytes"), testIdentity, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := encodeToken(secret, testIdentity, now.Add(-time.Hour), now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs256 := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), ErrInvalidToken},
+		{"tampered claims", parts[0] + "." + forgedParts[1] + "." + parts[2], ErrInvalidToken},
+		{"other secret", otherSecret, ErrInvalidToken},
+		{"expired", expired, ErrExpiredToken},
+		{"RS256", rs256 + "." + parts[1] + "." + parts[2], ErrNotSessionToken},
+		{"alg none", none + "." + parts[1] + ".", ErrNotSessionToken},
+		{"not a jwt", "facebook-token", ErrNotSessionToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := decodeToken(secret, test.token, now)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %+v, %v, want %v", identity, err, test.want)
+			}
+		})
+	}
+}
+
+func TestRefreshRotates(t *testing.T) {
+	testStores(t, func(t *testing.T, client *Client) {
+		ctx := context.Background()
+		session, err := client.Issue(ctx, testIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		refreshed, err := client.Refresh(ctx, session.RefreshToken, func(identity Identity) (*Identity, error) {
+			identity.Level = "ADVANCED"
+			return &identity, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshed.RefreshToken == session.RefreshToken {
+			t.Fatal("refresh token was not rotated")
+		}
+		identity, err := client.Verify(refreshed.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Level != "ADVANCED" {
+			t.Errorf("got level %q, want the updated ADVANCED", identity.Level)
+		}
+	})
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	testStores(t, func(t *testing.T, client *Client) {
+		ctx := context.Background()
+		session, err := client.Issue(ctx, testIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rotated, err := client.Refresh(ctx, session.RefreshToken, keepIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// another login of the same member is a family of its own
+		other, err := client.Issue(ctx, testIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.Refresh(ctx, session.RefreshToken, keepIdentity); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("reusing a rotated token: got %v, want ErrRefreshTokenReused", err)
+		}
+		if _, err := client.Refresh(ctx, rotated.RefreshToken, keepIdentity); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("refreshing the revoked session: got %v, want ErrInvalidRefreshToken", err)
+		}
+		if _, err := client.Refresh(ctx, other.RefreshToken, keepIdentity); err != nil {
+			t.Errorf("the other session was revoked too: %v", err)
+		}
+	})
+}
+
+func TestRefreshUpdateError(t *testing.T) {
+	testStores(t, func(t *testing.T, client *Client) {
+		ctx := context.Background()
+		session, err := client.Issue(ctx, testIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		notMember := errors.New("not a member")
+		_, err = client.Refresh(ctx, session.RefreshToken, func(Identity) (*Identity, error) {
+			return nil, notMember
+		})
+		if !errors.Is(err, notMember) {
+			t.Fatalf("got %v, want the error of update", err)
+		}
+		// the token was not spent on the failed refresh
+		if _, err := client.Refresh(ctx, session.RefreshToken, keepIdentity); err != nil {
+			t.Errorf("refresh after a failed update: %v", err)
+		}
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	testStores(t, func(t *testing.T, client *Client) {
+		ctx := context.Background()
+		session, err := client.Issue(ctx, testIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rotated, err := client.Refresh(ctx, session.RefreshToken, keepIdentity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.Revoke(ctx, rotated.RefreshToken); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Refresh(ctx, rotated.RefreshToken, keepIdentity); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("refresh after logout: got %v, want ErrInvalidRefreshToken", err)
+		}
+		if err := client.Revoke(ctx, rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("second logout: got %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+}
+
+func TestRefreshExpired(t *testing.T) {
+	client, err := New(testSecret, time.Minute, time.Nanosecond, NewMemoryStore(), testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.Issue(context.Background(), testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, err := client.Refresh(context.Background(), session.RefreshToken, keepIdentity); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+	}
+}
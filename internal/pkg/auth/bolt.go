@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var refreshBucket = []byte("refresh_tokens")
+
+// BoltStore keeps refresh tokens in a bbolt database of its own, so
+// sessions survive restarts.
+type BoltStore struct {
+	DB *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(refreshBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{DB: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.DB.Close()
+}
+
+func (s *BoltStore) Get(ctx context.Context, id string) (*RefreshToken, error) {
+	token := &RefreshToken{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		content := tx.Bucket(refreshBucket).Get([]byte(id))
+		if content == nil {
+			return ErrTokenNotFound
+		}
+		return json.Unmarshal(content, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *BoltStore) Save(ctx context.Context, id string, token RefreshToken) error {
+	content, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(refreshBucket)
+
+		now := time.Now()
+		expired := [][]byte{}
+		err := bucket.ForEach(func(key, value []byte) error {
+			stored := RefreshToken{}
+			if json.Unmarshal(value, &stored) == nil && now.After(stored.ExpiresAt) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return bucket.Put([]byte(id), content)
+	})
+}
+
+func (s *BoltStore) Use(ctx context.Context, id string) (bool, error) {
+	first := false
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(refreshBucket)
+		content := bucket.Get([]byte(id))
+		if content == nil {
+			return ErrTokenNotFound
+		}
+		token := RefreshToken{}
+		if err := json.Unmarshal(content, &token); err != nil {
+			return err
+		}
+		if token.Used {
+			return nil
+		}
+
+		token.Used = true
+		content, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		first = true
+		return bucket.Put([]byte(id), content)
+	})
+	return first, err
+}
+
+func (s *BoltStore) RevokeFamily(ctx context.Context, family string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(refreshBucket)
+		revoked := [][]byte{}
+		err := bucket.ForEach(func(key, value []byte) error {
+			stored := RefreshToken{}
+			if json.Unmarshal(value, &stored) == nil && stored.Family == family {
+				revoked = append(revoked, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range revoked {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrTokenNotFound = errors.New("refresh token not found")
+
+// RefreshToken is what the store knows about an issued refresh token.
+// Tokens rotated from one login share a family.
+type RefreshToken struct {
+	Family    string    `json:"family"`
+	Identity  Identity  `json:"identity"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+}
+
+// Store keeps refresh tokens by their id. Used tokens are kept until they
+// expire, so their reuse is noticed.
+type Store interface {
+	Get(ctx context.Context, id string) (*RefreshToken, error)
+	Save(ctx context.Context, id string, token RefreshToken) error
+	// Use marks the token used and reports false when it was used before.
+	Use(ctx context.Context, id string) (bool, error)
+	RevokeFamily(ctx context.Context, family string) error
+}
+
+// MemoryStore keeps refresh tokens in memory, members log in again after a
+// restart.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: map[string]RefreshToken{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, found := s.tokens[id]
+	if !found {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, id string, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, stored := range s.tokens {
+		if now.After(stored.ExpiresAt) {
+			delete(s.tokens, key)
+		}
+	}
+	s.tokens[id] = token
+	return nil
+}
+
+func (s *MemoryStore) Use(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, found := s.tokens[id]
+	if !found {
+		return false, ErrTokenNotFound
+	}
+	if token.Used {
+		return false, nil
+	}
+	token.Used = true
+	s.tokens[id] = token
+	return true, nil
+}
+
+func (s *MemoryStore) RevokeFamily(ctx context.Context, family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, stored := range s.tokens {
+		if stored.Family == family {
+			delete(s.tokens, key)
+		}
+	}
+	return nil
+}
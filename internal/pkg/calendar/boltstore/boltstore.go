@@ -0,0 +1,285 @@
+package boltstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	eventsBucket = []byte("events")
+	seriesBucket = []byte("series")
+)
+
+// Store keeps events in a local bbolt database, so the service can run
+// without Google Calendar. Every write bumps a version counter that is
+// used as the event ETag.
+type Store struct {
+	DB *bolt.DB
+}
+
+type record struct {
+	calendar.StoredEvent
+	Version uint64 `json:"version"`
+}
+
+func New(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{eventsBucket, seriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{DB: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+// ListEvents pages through the matching events by offset; the page token
+// is the encoded offset of the next page.
+func (s *Store) ListEvents(ctx context.Context, query calendar.EventQuery) ([]*calendar.StoredEvent, string, error) {
+	offset, err := decodePageToken(query.PageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	events := []*calendar.StoredEvent{}
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(eventsBucket).ForEach(func(_, value []byte) error {
+			rec, err := decode(value)
+			if err != nil {
+				return err
+			}
+			if rec.Start.Before(query.From) || (!query.To.IsZero() && !rec.Start.Before(query.To)) {
+				return nil
+			}
+			events = append(events, rec.toEvent())
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+
+	if offset >= len(events) {
+		return []*calendar.StoredEvent{}, "", nil
+	}
+	events = events[offset:]
+
+	nextPageToken := ""
+	if query.Limit > 0 && len(events) > query.Limit {
+		events = events[:query.Limit]
+		nextPageToken = encodePageToken(offset + query.Limit)
+	}
+	return events, nextPageToken, nil
+}
+
+func (s *Store) GetEvent(ctx context.Context, id string) (*calendar.StoredEvent, error) {
+	var event *calendar.StoredEvent
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		rec, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		event = rec.toEvent()
+		return nil
+	})
+	return event, err
+}
+
+func (s *Store) CreateEvent(ctx context.Context, event *calendar.StoredEvent) (*calendar.StoredEvent, error) {
+	rec := record{StoredEvent: *event, Version: 1}
+	if rec.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		rec.ID = id
+	}
+
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		return put(tx, &rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec.toEvent(), nil
+}
+
+func (s *Store) UpdateEvent(ctx context.Context, event *calendar.StoredEvent) (*calendar.StoredEvent, error) {
+	var updated *calendar.StoredEvent
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		current, err := get(tx, event.ID)
+		if err != nil {
+			return err
+		}
+		if strconv.FormatUint(current.Version, 10) != event.ETag {
+			return calendar.ErrConflict
+		}
+
+		rec := record{StoredEvent: *event, Version: current.Version + 1}
+		if err := put(tx, &rec); err != nil {
+			return err
+		}
+		updated = rec.toEvent()
+		return nil
+	})
+	return updated, err
+}
+
+func (s *Store) DeleteEvent(ctx context.Context, id string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return calendar.ErrEventNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (s *Store) ListSeries(ctx context.Context) ([]*calendar.Series, error) {
+	series := []*calendar.Series{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(seriesBucket).ForEach(func(_, value []byte) error {
+			item := &calendar.Series{}
+			if err := json.Unmarshal(value, item); err != nil {
+				return err
+			}
+			series = append(series, item)
+			return nil
+		})
+	})
+	return series, err
+}
+
+func (s *Store) GetSeries(ctx context.Context, id string) (*calendar.Series, error) {
+	series := &calendar.Series{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(seriesBucket).Get([]byte(id))
+		if value == nil {
+			return calendar.ErrSeriesNotFound
+		}
+		return json.Unmarshal(value, series)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func (s *Store) SaveSeries(ctx context.Context, series *calendar.Series) (*calendar.Series, error) {
+	saved := *series
+	if saved.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		saved.ID = id
+	}
+
+	value, err := json.Marshal(saved)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(seriesBucket).Put([]byte(saved.ID), value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (s *Store) DeleteSeries(ctx context.Context, id string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(seriesBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return calendar.ErrSeriesNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func get(tx *bolt.Tx, id string) (*record, error) {
+	value := tx.Bucket(eventsBucket).Get([]byte(id))
+	if value == nil {
+		return nil, calendar.ErrEventNotFound
+	}
+	return decode(value)
+}
+
+func put(tx *bolt.Tx, rec *record) error {
+	rec.ETag = ""
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(eventsBucket).Put([]byte(rec.ID), value)
+}
+
+func decode(value []byte) (*record, error) {
+	rec := &record{}
+	if err := json.Unmarshal(value, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *record) toEvent() *calendar.StoredEvent {
+	event := r.StoredEvent
+	event.ETag = strconv.FormatUint(r.Version, 10)
+	return &event
+}
+
+func encodePageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, calendar.ErrInvalidPageToken
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, calendar.ErrInvalidPageToken
+	}
+	return offset, nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,151 @@
+package boltstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := New(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestUpdateEventRefusesStaleETag(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	created, err := store.CreateEvent(ctx, &calendar.StoredEvent{Summary: "Training", Start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := *created
+	first.Summary = "First"
+	updated, err := store.UpdateEvent(ctx, &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ETag == created.ETag {
+		t.Fatalf("ETag %q did not change on update", updated.ETag)
+	}
+
+	second := *created
+	second.Summary = "Second"
+	if _, err := store.UpdateEvent(ctx, &second); !errors.Is(err, calendar.ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+
+	stored, err := store.GetEvent(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Summary != "First" || stored.ETag != updated.ETag {
+		t.Errorf("got %q with ETag %q, want the first update with ETag %q", stored.Summary, stored.ETag, updated.ETag)
+	}
+}
+
+func TestUpdateEventMissing(t *testing.T) {
+	store := newTestStore(t)
+
+	_, err := store.UpdateEvent(context.Background(), &calendar.StoredEvent{ID: "missing", ETag: "1"})
+	if !errors.Is(err, calendar.ErrEventNotFound) {
+		t.Fatalf("got %v, want ErrEventNotFound", err)
+	}
+}
+
+func TestListEventsPages(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	start := time.Date(2022, 9, 1, 18, 0, 0, 0, time.UTC)
+	// created out of order, listed by start
+	for _, day := range []int{3, 0, 4, 1, 2} {
+		_, err := store.CreateEvent(ctx, &calendar.StoredEvent{
+			ID:    fmt.Sprintf("day%d", day),
+			Start: start.AddDate(0, 0, day),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// outside of the queried range
+	_, err := store.CreateEvent(ctx, &calendar.StoredEvent{ID: "before", Start: start.AddDate(0, 0, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := calendar.EventQuery{From: start, Limit: 2}
+	ids := []string{}
+	pages := 0
+	for {
+		events, next, err := store.ListEvents(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) > query.Limit {
+			t.Fatalf("got %d events, the limit is %d", len(events), query.Limit)
+		}
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		query.PageToken = next
+	}
+
+	want := []string{"day0", "day1", "day2", "day3", "day4"}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+	if pages != 3 {
+		t.Errorf("got %d pages, want 3", pages)
+	}
+}
+
+func TestListEventsTo(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	start := time.Date(2022, 9, 1, 18, 0, 0, 0, time.UTC)
+	for day := 0; day < 3; day++ {
+		_, err := store.CreateEvent(ctx, &calendar.StoredEvent{
+			ID:    fmt.Sprintf("day%d", day),
+			Start: start.AddDate(0, 0, day),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, next, err := store.ListEvents(ctx, calendar.EventQuery{From: start, To: start.AddDate(0, 0, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || next != "" {
+		t.Errorf("got %d events and page token %q, want 2 events on one page", len(events), next)
+	}
+}
+
+func TestListEventsInvalidPageToken(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, token := range []string{"not base64!", encodePageToken(-1), "YWJj"} {
+		_, _, err := store.ListEvents(context.Background(), calendar.EventQuery{PageToken: token})
+		if !errors.Is(err, calendar.ErrInvalidPageToken) {
+			t.Errorf("token %q: got %v, want ErrInvalidPageToken", token, err)
+		}
+	}
+}
@@ -4,80 +4,43 @@ import (
 	"context"
 
 	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
 	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
-	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
 )
 
 type Client struct {
-	Service    *calendar.Service
-	CalendarID string
-	Logger     *logging.Logger
-	Swish      swish.API
+	Store    EventStore
+	Logger   *logging.Logger
+	Notifier notify.API
+	// Refunder is nil when card payments are not enabled.
+	Refunder Refunder
 }
 
 type API interface {
-	GetEvents(ctx context.Context) ([]*Event, error)
-	GetCalendars() (*calendar.CalendarList, error)
-	AddAttendeeEvent(ctx context.Context, eventDate string, payment *Payment, userInfo *spreadsheet.User) (*Event, error)
-	RemoveAttendee(ctx context.Context, eventDate string, userInfo *spreadsheet.User) (*Event, error)
-	GetSingleEvent(ctx context.Context, eventDate string, userInfo *spreadsheet.User) (*calendar.Event, *Description, error)
-	UpdateEvent(ctx context.Context, eventDate string, userInfo *spreadsheet.User) (*Event, error)
-	GoogleEventToEvent(gEvent *calendar.Event) (*Event, error)
+	GetEvents(ctx context.Context, filter EventFilter) (*EventPage, error)
+	GetEvent(ctx context.Context, eventID string) (*Event, error)
+	AddAttendeeEvent(ctx context.Context, eventID string, payment *Payment, userInfo *spreadsheet.User) (*Event, error)
+	RemoveAttendee(ctx context.Context, eventID string, userInfo *spreadsheet.User) (*Event, error)
+	UpdateEvent(ctx context.Context, eventID string, userInfo *spreadsheet.User) (*Event, error)
+	CreateEvent(ctx context.Context, session Session) (*Event, error)
+	EditEvent(ctx context.Context, eventID string, session Session) (*Event, error)
+	DeleteEvent(ctx context.Context, eventID string, force bool) error
+	ListSeries(ctx context.Context) ([]*Series, error)
+	GetSeries(ctx context.Context, seriesID string) (*Series, error)
+	SaveSeries(ctx context.Context, series Series) (*SeriesSync, error)
+	DeleteSeries(ctx context.Context, seriesID string) (*SeriesSync, error)
+	CancelEvent(ctx context.Context, eventID string, reason string) (*Cancellation, error)
+	RecordPayment(ctx context.Context, eventID string, userInfo *spreadsheet.User, update PaymentUpdate) (*Event, error)
+	ListClaims(ctx context.Context, query EventQuery) ([]Claim, error)
+	ReviewClaims(ctx context.Context, reviews []ClaimReview) []ClaimReviewResult
+	ConfirmPayment(ctx context.Context, eventID string, payment Payment) (*Event, error)
 }
 
-func New(serviceAccount string, calendarID string, logger *logging.Logger, swishService swish.API) (*Client, error) {
-	service, err := getClient(serviceAccount, logger)
-	if err != nil {
-		logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "unable to retrieve Calendar client",
-				"error":   err,
-			}},
-		)
-		return nil, err
-	}
-
+func New(store EventStore, logger *logging.Logger, notifier notify.API, refunder Refunder) *Client {
 	return &Client{
-		CalendarID: calendarID,
-		Service:    service,
-		Logger:     logger,
-		Swish:      swishService,
-	}, nil
-}
-
-func getClient(serviceAccount string, logger *logging.Logger) (*calendar.Service, error) {
-	ctx := context.Background()
-	credentials, err := google.CredentialsFromJSON(ctx, []byte(serviceAccount), calendar.CalendarEventsScope)
-	if err != nil {
-		logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "unable read credentials",
-				"error":   err,
-			}},
-		)
-		return nil, err
+		Store:    store,
+		Logger:   logger,
+		Notifier: notifier,
+		Refunder: refunder,
 	}
-
-	srv, err := calendar.NewService(ctx, option.WithCredentials(credentials))
-	if err != nil {
-		logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "unable authenticate to Calendar API",
-				"error":   err,
-			}},
-		)
-		return nil, err
-	}
-
-	return srv, err
-}
-
-func (c *Client) GetCalendars() (*calendar.CalendarList, error) {
-	return c.Service.CalendarList.List().Do()
 }
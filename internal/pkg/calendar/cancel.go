@@ -0,0 +1,106 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+)
+
+var (
+	ErrEventCancelled   = errors.New("event is cancelled")
+	ErrAlreadyCancelled = errors.New("event is already cancelled")
+)
+
+// Cancellation is the outcome of cancelling an event: who was told about
+// it and who has to get their money back.
+type Cancellation struct {
+	Event              *Event   `json:"event"`
+	Refunds            Payments `json:"refunds"`
+	Notified           []string `json:"notified"`
+	NotificationFailed []string `json:"notification_failed"`
+}
+
+// CancelEvent marks the event as cancelled instead of deleting it, so the
+// attendees and payments stay on record, and notifies everyone who signed
+// up or is waiting.
+func (c *Client) CancelEvent(ctx context.Context, eventID string, reason string) (*Cancellation, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, &ValidationError{Field: "reason", Reason: "is required"}
+	}
+
+	var cancelled *Description
+	event, err := c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		if description.Cancelled {
+			return ErrAlreadyCancelled
+		}
+		description.Cancelled = true
+		description.CancelReason = reason
+		description.CancelledAt = time.Now()
+		cancelled = description
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Cancellation{
+		Event:              event,
+		Refunds:            cancelled.Payments.paid(),
+		Notified:           []string{},
+		NotificationFailed: []string{},
+	}
+
+	recipients := append(append([]Attendee{}, cancelled.Attendees...), cancelled.Waitlist...)
+	for _, attendee := range recipients {
+		err := c.Notifier.Notify(ctx, cancellationMessage(event, attendee, reason, cancelled.Payments.HasUserPaid(attendee.Email)))
+		if err != nil {
+			c.Logger.Log(logging.Entry{
+				Severity: logging.Error,
+				Payload: map[string]interface{}{
+					"message": "could not notify attendee",
+					"event":   event.ID,
+					"user":    attendee.Email,
+					"error":   err,
+				}},
+			)
+			result.NotificationFailed = append(result.NotificationFailed, attendee.Email)
+			continue
+		}
+		result.Notified = append(result.Notified, attendee.Email)
+	}
+
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message":  "cancelled event",
+			"event":    event.ID,
+			"reason":   reason,
+			"refunds":  result.Refunds,
+			"notified": len(result.Notified),
+		}},
+	)
+	return result, nil
+}
+
+func cancellationMessage(event *Event, attendee Attendee, reason string, paid bool) notify.Message {
+	date := event.Date.Format(model.DateLayout)
+	body := fmt.Sprintf("Hi %s,\n\nthe session %q on %s at %s is cancelled: %s\n",
+		attendee.Name, event.Name, date, event.Date.Format("15:04"), reason)
+	if paid {
+		body += "\nYou have paid for this session and will get your money back.\n"
+	}
+
+	return notify.Message{
+		To:      attendee.Email,
+		Name:    attendee.Name,
+		Subject: fmt.Sprintf("Cancelled: %s %s", event.Name, date),
+		Body:    body,
+	}
+}
@@ -0,0 +1,245 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+)
+
+var (
+	ErrClaimNotFound    = errors.New("no claimed payment for this member")
+	ErrPaymentConfirmed = errors.New("payment was confirmed by the treasurer")
+	ErrClaimRejected    = errors.New("the treasurer could not find your payment, pay in the app or contact the treasurer")
+	ErrNotAttending     = errors.New("sign up for the event before marking it paid")
+)
+
+// Claim is a payment a member made by hand, waiting for the treasurer.
+type Claim struct {
+	EventID   string    `json:"event_id"`
+	EventName string    `json:"event_name"`
+	Date      time.Time `json:"date"`
+	Payment
+}
+
+// ClaimReview is the decision of the treasurer on the claim of Email for
+// EventID. Reason is sent to the member when the claim is rejected.
+type ClaimReview struct {
+	EventID string `json:"event_id"`
+	Email   string `json:"email"`
+	Confirm bool   `json:"confirm"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ClaimReviewResult tells how a single review went, a bulk review does
+// not stop at the first claim that fails.
+type ClaimReviewResult struct {
+	ClaimReview
+	Status   string `json:"status,omitempty"`
+	Notified bool   `json:"notified,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// unconfirmedAmount sums the claimed payments in öre. Claims from before
+// payments had an amount are counted at the event price.
+func (d *Description) unconfirmedAmount() int64 {
+	total := int64(0)
+	for _, payment := range d.Payments {
+		if payment.Status != PaymentClaimed {
+			continue
+		}
+		if payment.Amount > 0 {
+			total += payment.Amount
+		} else {
+			total += int64(d.Price) * 100
+		}
+	}
+	return total
+}
+
+// ListClaims returns the claimed payments of the events matching query,
+// oldest event first. Events with invalid descriptions are left out.
+func (c *Client) ListClaims(ctx context.Context, query EventQuery) ([]Claim, error) {
+	claims := []Claim{}
+	query.Limit = 250
+	for {
+		page, nextPageToken, err := c.Store.ListEvents(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range page {
+			description, err := readDescription(event)
+			if err != nil {
+				continue
+			}
+			for _, payment := range description.Payments {
+				if payment.Status != PaymentClaimed {
+					continue
+				}
+				if payment.Amount == 0 {
+					payment.Amount = int64(description.Price) * 100
+				}
+				claims = append(claims, Claim{
+					EventID:   event.ID,
+					EventName: event.Summary,
+					Date:      event.Start,
+					Payment:   payment,
+				})
+			}
+		}
+
+		if nextPageToken == "" {
+			return claims, nil
+		}
+		query.PageToken = nextPageToken
+	}
+}
+
+// ReviewClaims confirms or rejects claimed payments. Members whose claim
+// is rejected are notified.
+func (c *Client) ReviewClaims(ctx context.Context, reviews []ClaimReview) []ClaimReviewResult {
+	results := make([]ClaimReviewResult, len(reviews))
+	for index, review := range reviews {
+		results[index] = c.reviewClaim(ctx, review)
+	}
+	return results
+}
+
+func (c *Client) reviewClaim(ctx context.Context, review ClaimReview) ClaimReviewResult {
+	result := ClaimReviewResult{ClaimReview: review}
+
+	status := PaymentConfirmed
+	if !review.Confirm {
+		status = PaymentRejected
+	}
+
+	var rejected Payment
+	event, err := c.modifyEvent(ctx, review.EventID, func(oldEvent *StoredEvent, description *Description) error {
+		for index := range description.Payments {
+			payment := &description.Payments[index]
+			if strings.EqualFold(payment.Email, review.Email) && payment.Status == PaymentClaimed {
+				payment.Status = status
+				rejected = *payment
+				return nil
+			}
+		}
+		return ErrClaimNotFound
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = status
+
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message": "reviewed payment claim",
+			"event":   event.ID,
+			"user":    review.Email,
+			"status":  status,
+		}},
+	)
+
+	if review.Confirm {
+		return result
+	}
+
+	err = c.Notifier.Notify(ctx, rejectionMessage(event, rejected, review.Reason))
+	if err != nil {
+		c.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not notify member of rejected payment",
+				"event":   event.ID,
+				"user":    review.Email,
+				"error":   err,
+			}},
+		)
+		return result
+	}
+	result.Notified = true
+	return result
+}
+
+func rejectionMessage(event *Event, payment Payment, reason string) notify.Message {
+	date := event.Date.Format(model.DateLayout)
+	name := payment.Email
+	if index := attendeeIndex(event.Attendees, payment.Email); index >= 0 {
+		name = event.Attendees[index].Name
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nthe treasurer could not find your %s payment for %q on %s.\n",
+		name, payment.Method, event.Name, date)
+	if reason != "" {
+		body += fmt.Sprintf("\n%s\n", reason)
+	}
+	body += "\nPlease pay again or contact the treasurer.\n"
+
+	return notify.Message{
+		To:      payment.Email,
+		Name:    name,
+		Subject: fmt.Sprintf("Payment not found: %s %s", event.Name, date),
+		Body:    body,
+	}
+}
+
+// ConfirmPayment records a payment found on a bank statement, see
+// Payments.Confirm.
+func (c *Client) ConfirmPayment(ctx context.Context, eventID string, payment Payment) (*Event, error) {
+	return c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		payments, changed := description.Payments.Confirm(payment)
+		if !changed {
+			return errNoChange
+		}
+		description.Payments = payments
+		return nil
+	})
+}
+
+// Confirm returns p with payment confirmed. It confirms the claim or the
+// pending payment of the member, or adds the payment when they never
+// claimed it. Payments are told apart by reference, so importing the same
+// statement twice changes nothing, and members who already paid get no
+// second payment. It returns false when nothing changed.
+func (p Payments) Confirm(payment Payment) (Payments, bool) {
+	payment.Status = PaymentConfirmed
+	for index := range p {
+		if payment.Reference != "" && p[index].Reference == payment.Reference {
+			return p, false
+		}
+	}
+
+	for index := range p {
+		existing := &p[index]
+		if !strings.EqualFold(existing.Email, payment.Email) || existing.Method != payment.Method {
+			continue
+		}
+		switch {
+		case existing.Status == PaymentPending:
+			// a payment request whose callback never came, it keeps its
+			// reference so a late callback still finds it
+			existing.Status = PaymentConfirmed
+			existing.Amount = payment.Amount
+			existing.PaidTimestamp = payment.PaidTimestamp
+			return p, true
+		case existing.Status == PaymentClaimed || (existing.Status == PaymentConfirmed && existing.Reference == ""):
+			// a claim, or a payment the treasurer confirmed by hand
+			existing.Status = PaymentConfirmed
+			existing.Amount = payment.Amount
+			existing.Reference = payment.Reference
+			return p, true
+		}
+	}
+
+	if p.HasUserPaid(payment.Email) {
+		return p, false
+	}
+	return append(p, payment), true
+}
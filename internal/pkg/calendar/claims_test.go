@@ -0,0 +1,250 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+)
+
+// fakeNotifier keeps the messages sent to members, and fails while err is
+// set.
+type fakeNotifier struct {
+	mu       sync.Mutex
+	err      error
+	messages []notify.Message
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, message notify.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+var claimMember = &spreadsheet.User{Name: "Member", Email: "member@example.com"}
+
+// claimEvent has claimMember signed up with a claimed Swish payment.
+func claimEvent() StoredEvent {
+	return testEvent(Description{
+		Price:           100,
+		MaxParticipants: 10,
+		Attendees:       []Attendee{{Name: claimMember.Name, Email: claimMember.Email, SignTime: time.Now()}},
+		Payments: Payments{{
+			Email:         claimMember.Email,
+			PaidTimestamp: time.Now(),
+			Method:        MethodSwish,
+			Status:        PaymentClaimed,
+			Amount:        10000,
+		}},
+	})
+}
+
+func newClaimClient(t *testing.T, store EventStore) (*Client, *fakeNotifier) {
+	notifier := &fakeNotifier{}
+	return New(store, testLogger(t), notifier, nil), notifier
+}
+
+func TestReviewClaims(t *testing.T) {
+	other := claimEvent()
+	other.ID = "other"
+	client, notifier := newClaimClient(t, newMemoryStore(claimEvent(), other))
+	ctx := context.Background()
+
+	results := client.ReviewClaims(ctx, []ClaimReview{
+		{EventID: "event", Email: "MEMBER@example.com", Confirm: true},
+		{EventID: "other", Email: claimMember.Email, Reason: "No payment on 2 January."},
+		{EventID: "other", Email: claimMember.Email, Confirm: true},
+		{EventID: "missing", Email: claimMember.Email, Confirm: true},
+	})
+
+	want := []ClaimReviewResult{
+		{Status: PaymentConfirmed},
+		{Status: PaymentRejected, Notified: true},
+		{Error: ErrClaimNotFound.Error()},
+		{Error: ErrEventNotFound.Error()},
+	}
+	for index, result := range results {
+		if result.Status != want[index].Status || result.Notified != want[index].Notified || result.Error != want[index].Error {
+			t.Errorf("results[%d]: got %+v, want %+v", index, result, want[index])
+		}
+	}
+
+	for id, status := range map[string]string{"event": PaymentConfirmed, "other": PaymentRejected} {
+		event, err := client.GetEvent(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := event.PaymentStatusOf(claimMember.Email); got != status {
+			t.Errorf("%s: got payment status %q, want %q", id, got, status)
+		}
+	}
+
+	if len(notifier.messages) != 1 {
+		t.Fatalf("got messages %+v, want the rejection", notifier.messages)
+	}
+	message := notifier.messages[0]
+	if message.To != claimMember.Email || message.Name != claimMember.Name ||
+		!strings.HasPrefix(message.Subject, "Payment not found: Training") ||
+		!strings.Contains(message.Body, "Hi Member,") || !strings.Contains(message.Body, "swish payment") ||
+		!strings.Contains(message.Body, "No payment on 2 January.") {
+		t.Errorf("got rejection %+v", message)
+	}
+}
+
+func TestReviewClaimsNotifyError(t *testing.T) {
+	client, notifier := newClaimClient(t, newMemoryStore(claimEvent()))
+	notifier.err = errors.New("mail server down")
+
+	results := client.ReviewClaims(context.Background(), []ClaimReview{{EventID: "event", Email: claimMember.Email}})
+	if results[0].Status != PaymentRejected || results[0].Notified || results[0].Error != "" {
+		t.Errorf("got %+v, want a rejection without notification", results[0])
+	}
+}
+
+func TestUpdateEventAfterRejection(t *testing.T) {
+	client, _ := newClaimClient(t, newMemoryStore(claimEvent()))
+	ctx := context.Background()
+
+	client.ReviewClaims(ctx, []ClaimReview{{EventID: "event", Email: claimMember.Email}})
+
+	if _, err := client.UpdateEvent(ctx, "event", claimMember); !errors.Is(err, ErrClaimRejected) {
+		t.Fatalf("claiming again: got %v, want ErrClaimRejected", err)
+	}
+	event, err := client.GetEvent(ctx, "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Payments) != 1 || event.PaymentStatusOf(claimMember.Email) != PaymentRejected {
+		t.Errorf("got payments %+v", event.Payments)
+	}
+}
+
+func TestUpdateEventRequiresAttendance(t *testing.T) {
+	waiting := testEvent(Description{
+		Price:           100,
+		MaxParticipants: 1,
+		Attendees:       []Attendee{{Name: "Other", Email: "other@example.com"}},
+		Waitlist:        []Attendee{{Name: claimMember.Name, Email: claimMember.Email}},
+	})
+	client, _ := newClaimClient(t, newMemoryStore(waiting))
+	ctx := context.Background()
+
+	if _, err := client.UpdateEvent(ctx, "event", claimMember); !errors.Is(err, ErrNotAttending) {
+		t.Fatalf("claim while waiting: got %v, want ErrNotAttending", err)
+	}
+	event, err := client.UpdateEvent(ctx, "event", &spreadsheet.User{Name: "Other", Email: "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.PaymentStatusOf("other@example.com") != PaymentClaimed {
+		t.Errorf("got payments %+v", event.Payments)
+	}
+}
+
+func TestConfirmPayment(t *testing.T) {
+	paid := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	statement := Payment{
+		Email:         "member@example.com",
+		PaidTimestamp: paid,
+		Method:        MethodSwish,
+		Amount:        10000,
+		Reference:     "bank-1",
+	}
+
+	tests := []struct {
+		name     string
+		payments Payments
+		want     Payments
+	}{
+		{
+			name: "no payment",
+			want: Payments{{Email: "member@example.com", PaidTimestamp: paid, Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"}},
+		},
+		{
+			name:     "claim",
+			payments: Payments{{Email: "Member@example.com", Method: MethodSwish, Status: PaymentClaimed}},
+			want:     Payments{{Email: "Member@example.com", Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"}},
+		},
+		{
+			name:     "confirmed by hand",
+			payments: Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentConfirmed}},
+			want:     Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"}},
+		},
+		{
+			name:     "pending request",
+			payments: Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentPending, Reference: "request"}},
+			want:     Payments{{Email: "member@example.com", PaidTimestamp: paid, Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "request"}},
+		},
+		{
+			name:     "pending card payment",
+			payments: Payments{{Email: "member@example.com", Method: MethodStripe, Status: PaymentPending, Reference: "cs_test"}},
+			want: Payments{
+				{Email: "member@example.com", Method: MethodStripe, Status: PaymentPending, Reference: "cs_test"},
+				{Email: "member@example.com", PaidTimestamp: paid, Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"},
+			},
+		},
+		{
+			name:     "paid by card",
+			payments: Payments{{Email: "member@example.com", Method: MethodStripe, Status: PaymentConfirmed, Reference: "cs_test"}},
+			want:     Payments{{Email: "member@example.com", Method: MethodStripe, Status: PaymentConfirmed, Reference: "cs_test"}},
+		},
+		{
+			name:     "confirmed request",
+			payments: Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentConfirmed, Reference: "request"}},
+			want:     Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentConfirmed, Reference: "request"}},
+		},
+		{
+			name:     "imported before",
+			payments: Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"}},
+			want:     Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"}},
+		},
+		{
+			name:     "rejected claim",
+			payments: Payments{{Email: "member@example.com", Method: MethodSwish, Status: PaymentRejected}},
+			want: Payments{
+				{Email: "member@example.com", Method: MethodSwish, Status: PaymentRejected},
+				{Email: "member@example.com", PaidTimestamp: paid, Method: MethodSwish, Status: PaymentConfirmed, Amount: 10000, Reference: "bank-1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, newMemoryStore(testEvent(Description{Price: 100, Payments: test.payments})))
+
+			// a second import of the same statement changes nothing
+			for i := 0; i < 2; i++ {
+				if _, err := client.ConfirmPayment(context.Background(), "event", statement); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, description, err := client.getStoredEvent(context.Background(), "event")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(description.Payments) != len(test.want) {
+				t.Fatalf("got payments %+v, want %+v", description.Payments, test.want)
+			}
+			for index, payment := range description.Payments {
+				want := test.want[index]
+				if !payment.PaidTimestamp.Equal(want.PaidTimestamp) {
+					t.Errorf("payments[%d]: paid at %v, want %v", index, payment.PaidTimestamp, want.PaidTimestamp)
+				}
+				payment.PaidTimestamp, want.PaidTimestamp = time.Time{}, time.Time{}
+				if payment != want {
+					t.Errorf("payments[%d]: got %+v, want %+v", index, payment, want)
+				}
+			}
+		})
+	}
+}
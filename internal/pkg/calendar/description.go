@@ -0,0 +1,214 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentDescriptionVersion is the version written to every description.
+// Older descriptions are upgraded by migrations when they are read.
+var CurrentDescriptionVersion = len(migrations) + 1
+
+// migrations[i] upgrades a description document from version i+1 to i+2.
+var migrations = []func(doc map[string]interface{}) error{
+	renameAttendesKey,
+	addPaymentMethod,
+}
+
+// DescriptionError tells which event and which description field could
+// not be read.
+type DescriptionError struct {
+	EventID string `json:"event_id"`
+	Field   string `json:"field,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func (e *DescriptionError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("event %s: invalid description: %s", e.EventID, e.Reason)
+	}
+	return fmt.Sprintf("event %s: invalid description field %s: %s", e.EventID, e.Field, e.Reason)
+}
+
+// renameAttendesKey moves the attendees from the misspelled key used by
+// version 1 descriptions.
+func renameAttendesKey(doc map[string]interface{}) error {
+	attendees, found := doc["attendes"]
+	if !found {
+		return nil
+	}
+	if _, found := doc["attendees"]; found {
+		return &DescriptionError{Field: "attendes", Reason: "both attendes and attendees are set"}
+	}
+	doc["attendees"] = attendees
+	delete(doc, "attendes")
+	return nil
+}
+
+// addPaymentMethod sets the method and status of version 2 payments.
+// Payments with a reference came from Stripe, and so did the ones without
+// a paid timestamp, which the first Stripe webhook wrote. The others were
+// marked by the members themselves and are only claimed.
+func addPaymentMethod(doc map[string]interface{}) error {
+	payments, ok := doc["payments"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	for index, item := range payments {
+		payment, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return &DescriptionError{Field: fmt.Sprintf("payments[%d]", index), Reason: "is not a payment"}
+		}
+		if _, found := payment["method"]; found {
+			continue
+		}
+
+		if _, found := payment["reference"]; found {
+			payment["method"] = MethodStripe
+			if payment["status"] == "paid" {
+				payment["status"] = PaymentConfirmed
+			}
+			continue
+		}
+		if _, found := payment["status"]; !found && !hasPaidTimestamp(payment) {
+			payment["method"] = MethodStripe
+			payment["status"] = PaymentConfirmed
+			continue
+		}
+		payment["method"] = MethodSwish
+		if _, found := payment["status"]; !found {
+			payment["status"] = PaymentClaimed
+		}
+	}
+	return nil
+}
+
+// hasPaidTimestamp tells whether the payment has a paid timestamp other
+// than the zero time.
+func hasPaidTimestamp(payment map[interface{}]interface{}) bool {
+	switch value := payment["paid_timestamp"].(type) {
+	case nil:
+		return false
+	case time.Time:
+		return !value.IsZero()
+	case string:
+		parsed, err := time.Parse(time.RFC3339, value)
+		return err != nil || !parsed.IsZero()
+	default:
+		return true
+	}
+}
+
+// decodeDescription migrates the YAML document to the current version and
+// decodes it field by field, so errors can name the field that is wrong.
+func decodeDescription(content string) (*Description, error) {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, &DescriptionError{Reason: err.Error()}
+	}
+
+	version := 1
+	if value, found := doc["version"]; found {
+		v, ok := value.(int)
+		if !ok || v < 1 {
+			return nil, &DescriptionError{Field: "version", Reason: fmt.Sprintf("%v is not a version", value)}
+		}
+		version = v
+	}
+	if version > CurrentDescriptionVersion {
+		return nil, &DescriptionError{Field: "version", Reason: fmt.Sprintf("version %d is newer than this server", version)}
+	}
+	for ; version < CurrentDescriptionVersion; version++ {
+		if err := migrations[version-1](doc); err != nil {
+			return nil, err
+		}
+	}
+	doc["version"] = version
+
+	description := &Description{}
+	target := reflect.ValueOf(description).Elem()
+	fields := descriptionFields()
+	for key, value := range doc {
+		index, known := fields[key]
+		if !known {
+			return nil, &DescriptionError{Field: key, Reason: "unknown field"}
+		}
+
+		content, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, &DescriptionError{Field: key, Reason: err.Error()}
+		}
+		field := reflect.New(target.Field(index).Type())
+		if err := yaml.UnmarshalStrict(content, field.Interface()); err != nil {
+			return nil, &DescriptionError{Field: key, Reason: err.Error()}
+		}
+		target.Field(index).Set(field.Elem())
+	}
+
+	return description, description.validate()
+}
+
+// descriptionFields maps the YAML keys of Description to field indexes.
+func descriptionFields() map[string]int {
+	fields := map[string]int{}
+	descriptionType := reflect.TypeOf(Description{})
+	for i := 0; i < descriptionType.NumField(); i++ {
+		name := strings.Split(descriptionType.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func (d *Description) validate() error {
+	if d.Price < 0 {
+		return &DescriptionError{Field: "price", Reason: "cannot be negative"}
+	}
+	if d.Level != "" {
+		// organizers may write the level in any case
+		level, err := model.ParseLevel(d.Level)
+		if err != nil {
+			return &DescriptionError{Field: "level", Reason: err.Error()}
+		}
+		d.Level = level.String()
+	}
+	if d.MaxParticipants < 0 {
+		return &DescriptionError{Field: "max_participants", Reason: "cannot be negative"}
+	}
+	if d.Policy != nil {
+		var validationErr *ValidationError
+		if err := d.Policy.Validate(); errors.As(err, &validationErr) {
+			return &DescriptionError{Field: validationErr.Field, Reason: validationErr.Reason}
+		}
+	}
+	for index, attendee := range d.Attendees {
+		if attendee.Email == "" {
+			return &DescriptionError{Field: fmt.Sprintf("attendees[%d].email", index), Reason: "is required"}
+		}
+	}
+	for index, attendee := range d.Waitlist {
+		if attendee.Email == "" {
+			return &DescriptionError{Field: fmt.Sprintf("waitlist[%d].email", index), Reason: "is required"}
+		}
+	}
+	for index, payment := range d.Payments {
+		if payment.Email == "" {
+			return &DescriptionError{Field: fmt.Sprintf("payments[%d].email", index), Reason: "is required"}
+		}
+		if !validPaymentMethod(payment.Method) {
+			return &DescriptionError{Field: fmt.Sprintf("payments[%d].method", index), Reason: fmt.Sprintf("unknown method %q", payment.Method)}
+		}
+		if !validPaymentStatus(payment.Status) {
+			return &DescriptionError{Field: fmt.Sprintf("payments[%d].status", index), Reason: fmt.Sprintf("unknown status %q", payment.Status)}
+		}
+	}
+	return nil
+}
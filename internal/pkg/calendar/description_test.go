@@ -0,0 +1,191 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeDescriptionBaseline(t *testing.T) {
+	// written before descriptions had a version
+	description, err := decodeDescription(strings.Join([]string{
+		"price: 100",
+		"attendes:",
+		"- name: Member",
+		"  email: member@example.com",
+		"  sign_time: 2022-08-01T10:00:00Z",
+		"level: MEDIUM",
+		"max_participants: 12",
+		"payments:",
+		"- email: member@example.com",
+		"  paid_timestamp: 2022-08-02T10:00:00Z",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if description.Version != CurrentDescriptionVersion {
+		t.Errorf("got version %d, want %d", description.Version, CurrentDescriptionVersion)
+	}
+	if len(description.Attendees) != 1 || description.Attendees[0].Email != "member@example.com" {
+		t.Errorf("attendes were not moved to attendees: %+v", description.Attendees)
+	}
+	if description.Price != 100 || description.Level != "MEDIUM" || description.MaxParticipants != 12 {
+		t.Errorf("got %+v", description)
+	}
+	if len(description.Payments) != 1 {
+		t.Fatalf("got payments %+v", description.Payments)
+	}
+	if payment := description.Payments[0]; payment.Method != MethodSwish || payment.Status != PaymentClaimed {
+		t.Errorf("got a %s payment %s, want a claimed swish payment", payment.Method, payment.Status)
+	}
+}
+
+func TestDecodeDescriptionVersion2(t *testing.T) {
+	description, err := decodeDescription(strings.Join([]string{
+		"version: 2",
+		"price: 100",
+		"attendees:",
+		"- name: Member",
+		"  email: member@example.com",
+		"max_participants: 10",
+		"payments:",
+		"- email: member@example.com",
+		"  reference: cs_test",
+		"  status: paid",
+		"- email: other@example.com",
+		"  status: claimed",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ method, status string }{
+		{MethodStripe, PaymentConfirmed},
+		{MethodSwish, PaymentClaimed},
+	}
+	if len(description.Payments) != len(want) {
+		t.Fatalf("got payments %+v", description.Payments)
+	}
+	for index, payment := range description.Payments {
+		if payment.Method != want[index].method || payment.Status != want[index].status {
+			t.Errorf("payments[%d]: got %s %s, want %s %s", index,
+				payment.Method, payment.Status, want[index].method, want[index].status)
+		}
+	}
+}
+
+func TestDescriptionRoundTrip(t *testing.T) {
+	signTime := time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)
+	description := Description{
+		Price:           100,
+		Attendees:       []Attendee{{Name: "Member", Email: "member@example.com", SignTime: signTime}},
+		Level:           "ADVANCED",
+		MaxParticipants: 1,
+		Payments: Payments{{
+			Email:         "member@example.com",
+			PaidTimestamp: signTime,
+			Method:        MethodStripe,
+			Status:        PaymentConfirmed,
+			Amount:        10000,
+			Reference:     "cs_test",
+			PaymentIntent: "pi_test",
+		}},
+		Waitlist: []Attendee{{Name: "Waiting", Email: "waiting@example.com", SignTime: signTime}},
+		Series:   "series",
+		Policy:   &CancellationPolicy{SignOffHours: 24, PaymentRemovalHours: 48, Late: LateFee, LateFee: 50},
+	}
+
+	decoded, err := decodeDescription(description.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, &description) {
+		t.Errorf("got\n%+v\nwant\n%+v", decoded, &description)
+	}
+}
+
+func TestDecodeDescriptionErrors(t *testing.T) {
+	version := fmt.Sprintf("version: %d\n", CurrentDescriptionVersion)
+	tests := []struct {
+		name    string
+		content string
+		field   string
+	}{
+		{"not yaml", "price: [100", ""},
+		{"version not a number", "version: two", "version"},
+		{"version zero", "version: 0", "version"},
+		{"newer version", "version: 99", "version"},
+		{"attendes and attendees", "attendes: []\nattendees: []", "attendes"},
+		{"payment not a map", "version: 2\npayments:\n- member@example.com", "payments[0]"},
+		{"unknown field", version + "prize: 100", "prize"},
+		{"wrong type", version + "price: free", "price"},
+		{"unknown attendee field", version + "attendees:\n- mail: member@example.com", "attendees"},
+		{"negative price", version + "price: -1", "price"},
+		{"unknown level", version + "level: EXPERT", "level"},
+		{"negative max participants", version + "max_participants: -1", "max_participants"},
+		{"attendee without email", version + "attendees:\n- name: Member", "attendees[0].email"},
+		{"waitlist without email", version + "waitlist:\n- name: Member", "waitlist[0].email"},
+		{"payment without email", version + "payments:\n- method: swish\n  status: claimed", "payments[0].email"},
+		{"payment method", version + "payments:\n- email: a@example.com\n  method: bitcoin\n  status: claimed", "payments[0].method"},
+		{"payment status", version + "payments:\n- email: a@example.com\n  method: swish\n  status: maybe", "payments[0].status"},
+		{"policy", version + "policy:\n  sign_off_hours: -1\n  late: block", "policy.sign_off_hours"},
+		{"policy late", version + "policy:\n  late: never", "policy.late"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeDescription(test.content)
+			var descErr *DescriptionError
+			if !errors.As(err, &descErr) {
+				t.Fatalf("got %v, want a DescriptionError", err)
+			}
+			if descErr.Field != test.field {
+				t.Errorf("got field %q (%v), want %q", descErr.Field, err, test.field)
+			}
+		})
+	}
+}
+
+func TestReadDescriptionNamesEvent(t *testing.T) {
+	_, err := readDescription(&StoredEvent{ID: "event", Description: "price: -1"})
+	var descErr *DescriptionError
+	if !errors.As(err, &descErr) || descErr.EventID != "event" {
+		t.Fatalf("got %v, want a DescriptionError of event", err)
+	}
+}
+
+func TestDecodeDescriptionBaselineWebhookPayments(t *testing.T) {
+	// the first Stripe webhook recorded payments without a timestamp,
+	// members marking their Swish payments always had one
+	description, err := decodeDescription(strings.Join([]string{
+		"price: 100",
+		"attendes: []",
+		"payments:",
+		"- email: card@example.com",
+		"  paid_timestamp: 0001-01-01T00:00:00Z",
+		"- email: card2@example.com",
+		"- email: swish@example.com",
+		"  paid_timestamp: 2022-08-02T10:00:00Z",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct{ method, status string }{
+		"card@example.com":  {MethodStripe, PaymentConfirmed},
+		"card2@example.com": {MethodStripe, PaymentConfirmed},
+		"swish@example.com": {MethodSwish, PaymentClaimed},
+	}
+	if len(description.Payments) != len(want) {
+		t.Fatalf("got payments %+v", description.Payments)
+	}
+	for _, payment := range description.Payments {
+		if payment.Method != want[payment.Email].method || payment.Status != want[payment.Email].status {
+			t.Errorf("%s: got %s %s, want %s %s", payment.Email,
+				payment.Method, payment.Status, want[payment.Email].method, want[payment.Email].status)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package calendar
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"sort"
 	"strings"
 	"time"
@@ -10,13 +12,26 @@ import (
 	"cloud.google.com/go/logging"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
 	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
-
-	"google.golang.org/api/calendar/v3"
 	"gopkg.in/yaml.v2"
 )
 
-var ErrCannotRemovePayment = errors.New("cannot remove payment 2 days prior to event")
+var (
+	ErrCannotRemovePayment = errors.New("payment can no longer be removed")
+	ErrConcurrentUpdate    = errors.New("event was changed by someone else, try again")
+	ErrEventNotFound       = errors.New("event not found")
+	ErrAmbiguousEvent      = errors.New("several events on this date, use the event id")
+
+	// errNoChange is returned by a modifyEvent change function when the
+	// event is already in the wanted state and does not need to be written.
+	errNoChange = errors.New("no change")
+)
+
+const (
+	maxUpdateAttempts = 5
+	updateBackoff     = 100 * time.Millisecond
+)
 
 type Attendee struct {
 	Name     string    `json:"name" yaml:"name"`
@@ -26,33 +41,84 @@ type Attendee struct {
 
 type Payments []Payment
 type Description struct {
-	Price           int        `yaml:"price"`
-	Attendees       []Attendee `yaml:"attendes"`
-	Level           string     `yaml:"level,omitempty"`
-	MaxParticipants int        `yaml:"max_participants"`
-	Payments        Payments   `yaml:"payments"`
+	Version           int                      `yaml:"version"`
+	Price             int                      `yaml:"price"`
+	Attendees         []Attendee               `yaml:"attendees"`
+	Level             string                   `yaml:"level,omitempty"`
+	MaxParticipants   int                      `yaml:"max_participants"`
+	Payments          Payments                 `yaml:"payments"`
+	Waitlist          []Attendee               `yaml:"waitlist,omitempty"`
+	Series            string                   `yaml:"series,omitempty"`
+	Cancelled         bool                     `yaml:"cancelled,omitempty"`
+	CancelReason      string                   `yaml:"cancel_reason,omitempty"`
+	CancelledAt       time.Time                `yaml:"cancelled_at,omitempty"`
+	Policy            *CancellationPolicy      `yaml:"policy,omitempty"`
+	LateCancellations []LateCancellationRecord `yaml:"late_cancellations,omitempty"`
 }
 
 type Payment struct {
 	Email         string    `json:"email" yaml:"email"`
 	PaidTimestamp time.Time `json:"paid_timestamp" yaml:"paid_timestamp"`
+	Method        string    `json:"method" yaml:"method"`
+	Status        string    `json:"status" yaml:"status"`
+	// Amount is in öre, Description.Price is in SEK.
+	Amount int64 `json:"amount,omitempty" yaml:"amount,omitempty"`
+	// Reference identifies the payment at the payment provider.
+	Reference string `json:"reference,omitempty" yaml:"reference,omitempty"`
+	// the Stripe ids are only needed for refunds and stay on the server
+	PaymentIntent string `json:"-" yaml:"payment_intent,omitempty"`
+	Refund        string `json:"-" yaml:"refund,omitempty"`
+}
+
+// EventFilter narrows an EventQuery down to a single level.
+type EventFilter struct {
+	EventQuery
+	Level string
+}
+
+type EventPage struct {
+	Events        []*Event       `json:"events"`
+	Invalid       []InvalidEvent `json:"invalid,omitempty"`
+	NextPageToken string         `json:"next_page_token,omitempty"`
+}
+
+// InvalidEvent flags an event left out of a listing because its
+// description could not be read.
+type InvalidEvent struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Date  time.Time `json:"date"`
+	Field string    `json:"field,omitempty"`
+	Error string    `json:"error"`
 }
 
 type Event struct {
-	ID              string     `json:"id"`
-	Price           int        `json:"price"`
-	Name            string     `json:"name"`
-	Date            time.Time  `json:"date"`
-	Attendees       []Attendee `json:"attendees"`
-	Payments        Payments   `json:"payments"`
-	Local           string     `json:"local"`
-	Level           string     `json:"level"`
-	MaxParticipants int        `json:"max_participants"`
-	QrCode          string     `json:"qr_code"`
-}
-
-func (c *Client) GoogleEventToEvent(gEvent *calendar.Event) (*Event, error) {
-	description, err := readDescription(gEvent.Description)
+	ID                string                   `json:"id"`
+	Price             int                      `json:"price"`
+	Name              string                   `json:"name"`
+	Date              time.Time                `json:"date"`
+	Attendees         []Attendee               `json:"attendees"`
+	Payments          Payments                 `json:"payments"`
+	Local             string                   `json:"local"`
+	Level             string                   `json:"level"`
+	MaxParticipants   int                      `json:"max_participants"`
+	Waitlist          []Attendee               `json:"waitlist"`
+	WaitlistPosition  int                      `json:"waitlist_position,omitempty"`
+	PaymentStatus     string                   `json:"payment_status,omitempty"`
+	UnconfirmedAmount int64                    `json:"unconfirmed_amount"`
+	QrCodeURL         string                   `json:"qr_code_url,omitempty"`
+	SeriesID          string                   `json:"series_id,omitempty"`
+	Cancelled         bool                     `json:"cancelled"`
+	CancelReason      string                   `json:"cancel_reason,omitempty"`
+	Policy            CancellationPolicy       `json:"policy"`
+	LateCancellations []LateCancellationRecord `json:"late_cancellations,omitempty"`
+	// Refund is only set on the answer to the request that refunded a
+	// card payment.
+	Refund *Refund `json:"refund,omitempty"`
+}
+
+func (c *Client) ToEvent(stored *StoredEvent) (*Event, error) {
+	description, err := readDescription(stored)
 	if err != nil {
 		c.Logger.Log(logging.Entry{
 			Severity: logging.Error,
@@ -66,66 +132,154 @@ func (c *Client) GoogleEventToEvent(gEvent *calendar.Event) (*Event, error) {
 
 	level := model.StringToLevel(description.Level)
 
-	maxParticipants := description.MaxParticipants
-	if maxParticipants == 0 {
-		maxParticipants = model.DefaultMaxParticipants
-	}
-
 	retEvent := Event{
-		ID:              model.TimeToID(gEvent.Start.DateTime),
-		Date:            *model.TimeParse(gEvent.Start.DateTime),
-		Name:            gEvent.Summary,
-		Attendees:       description.Attendees,
-		Price:           description.Price,
-		Local:           gEvent.Location,
-		Level:           level.String(),
-		MaxParticipants: maxParticipants,
-		Payments:        description.Payments,
-	}
-
-	if description.Price > 0 {
-		qrcode, err := c.Swish.GenerateQrCode(description.Price, description.Level, model.TimeToID(gEvent.Start.DateTime))
-		if err != nil {
-			c.Logger.Log(logging.Entry{
-				Severity: logging.Error,
-				Payload: map[string]interface{}{
-					"message": "could not read description",
-					"event":   err,
-				}},
-			)
-			return nil, err
-		}
-		retEvent.QrCode = qrcode
+		ID:                stored.ID,
+		Date:              stored.Start,
+		Name:              stored.Summary,
+		Attendees:         description.Attendees,
+		Price:             description.Price,
+		Local:             stored.Location,
+		Level:             level.String(),
+		MaxParticipants:   description.Capacity(),
+		Payments:          description.Payments,
+		Waitlist:          description.Waitlist,
+		SeriesID:          description.Series,
+		Cancelled:         description.Cancelled,
+		CancelReason:      description.CancelReason,
+		Policy:            *description.policy(),
+		LateCancellations: description.LateCancellations,
+		UnconfirmedAmount: description.unconfirmedAmount(),
 	}
 
 	return &retEvent, nil
 }
 
-func readDescription(description string) (*Description, error) {
-	descObj := &Description{
-		Attendees: []Attendee{},
-	}
-
+// readDescription reads the booking state of event, upgrading descriptions
+// written by older versions. Errors are *DescriptionError.
+func readDescription(event *StoredEvent) (*Description, error) {
 	// remove html
-	withBreakline := strings.ReplaceAll(description, "<br>", "\n")
+	withBreakline := strings.ReplaceAll(event.Description, "<br>", "\n")
 	noNbsp := strings.ReplaceAll(withBreakline, "&nbsp;", " ")
 
 	p := bluemonday.StrictPolicy()
 	nonHtml := p.Sanitize(noNbsp)
 
-	err := yaml.Unmarshal([]byte(nonHtml), descObj)
+	descObj, err := decodeDescription(nonHtml)
 	if err != nil {
+		var descErr *DescriptionError
+		if errors.As(err, &descErr) {
+			descErr.EventID = event.ID
+		}
 		return nil, err
 	}
 
+	if descObj.Attendees == nil {
+		descObj.Attendees = []Attendee{}
+	}
+	if descObj.Waitlist == nil {
+		descObj.Waitlist = []Attendee{}
+	}
+
 	sort.Slice(descObj.Attendees, func(i, j int) bool {
 		return descObj.Attendees[j].SignTime.After(descObj.Attendees[i].SignTime)
 	})
+	sort.SliceStable(descObj.Waitlist, func(i, j int) bool {
+		return descObj.Waitlist[j].SignTime.After(descObj.Waitlist[i].SignTime)
+	})
 
 	return descObj, nil
 }
 
+// Capacity returns how many attendees the event accepts before new
+// sign-ups go to the waitlist.
+func (d *Description) Capacity() int {
+	if d.MaxParticipants <= 0 {
+		return model.DefaultMaxParticipants
+	}
+	return d.MaxParticipants
+}
+
+// IsFull reports whether the event has no spots left.
+func (d *Description) IsFull() bool {
+	return len(d.Attendees) >= d.Capacity()
+}
+
+// hasBookings reports whether anyone signed up, is waiting or paid.
+func (d *Description) hasBookings() bool {
+	return len(d.Attendees) > 0 || len(d.Waitlist) > 0 || len(d.Payments) > 0
+}
+
+// addAttendee signs user up, or puts them on the waitlist when the event
+// is full. It returns false when user already signed up or is waiting.
+func (d *Description) addAttendee(user *spreadsheet.User) bool {
+	if attendeeIndex(d.Attendees, user.Email) >= 0 || attendeeIndex(d.Waitlist, user.Email) >= 0 {
+		return false
+	}
+
+	attendee := Attendee{
+		Name:     user.Name,
+		Email:    user.Email,
+		SignTime: time.Now(),
+	}
+	if d.IsFull() {
+		d.Waitlist = append(d.Waitlist, attendee)
+	} else {
+		d.Attendees = append(d.Attendees, attendee)
+	}
+	return true
+}
+
+// promoteWaitlist moves people from the head of the waitlist to the
+// attendees while there are free spots.
+func (d *Description) promoteWaitlist() []Attendee {
+	promoted := []Attendee{}
+	for len(d.Waitlist) > 0 && !d.IsFull() {
+		promoted = append(promoted, d.Waitlist[0])
+		d.Attendees = append(d.Attendees, d.Waitlist[0])
+		d.Waitlist = d.Waitlist[1:]
+	}
+	return promoted
+}
+
+// HasSpotFor reports whether email attends the event or could still sign
+// up without waiting.
+func (e *Event) HasSpotFor(email string) bool {
+	return attendeeIndex(e.Attendees, email) >= 0 || len(e.Attendees) < e.MaxParticipants
+}
+
+// WaitlistPositionOf returns the 1-based position of email on the waitlist,
+// or zero when the user is not waiting.
+func (e *Event) WaitlistPositionOf(email string) int {
+	return attendeeIndex(e.Waitlist, email) + 1
+}
+
+// PaymentStatusOf returns the status of the payment email made for the
+// event, preferring one that covers it, or "" when there is none.
+func (e *Event) PaymentStatusOf(email string) string {
+	status := ""
+	for _, payment := range e.Payments {
+		if !strings.EqualFold(payment.Email, email) {
+			continue
+		}
+		if payment.IsPaid() {
+			return payment.Status
+		}
+		status = payment.Status
+	}
+	return status
+}
+
+func attendeeIndex(attendees []Attendee, email string) int {
+	for index := range attendees {
+		if strings.EqualFold(attendees[index].Email, email) {
+			return index
+		}
+	}
+	return -1
+}
+
 func (d *Description) String() string {
+	d.Version = CurrentDescriptionVersion
 	content, err := yaml.Marshal(d)
 	if err != nil {
 		return ""
@@ -133,77 +287,116 @@ func (d *Description) String() string {
 	return string(content)
 }
 
-func (c *Client) GetEvents(ctx context.Context) ([]*Event, error) {
-	events, err := c.Service.Events.List(c.CalendarID).
-		ShowDeleted(false).
-		SingleEvents(true).
-		TimeMin(time.Now().Format(time.RFC3339)).
-		MaxResults(10).
-		OrderBy("startTime").
-		Do()
-
+// GetEvents returns a page of events matching filter. The level filter
+// is applied after the store query, so a page can hold fewer events than
+// the requested limit and still have a next page.
+func (c *Client) GetEvents(ctx context.Context, filter EventFilter) (*EventPage, error) {
+	events, nextPageToken, err := c.Store.ListEvents(ctx, filter.EventQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	retEvents := []*Event{}
-	for _, ev := range events.Items {
-
-		e, err := c.GoogleEventToEvent(ev)
-
+	page := &EventPage{
+		Events:        []*Event{},
+		NextPageToken: nextPageToken,
+	}
+	for _, ev := range events {
+
+		e, err := c.ToEvent(ev)
+
+		var descErr *DescriptionError
+		if errors.As(err, &descErr) {
+			// one broken description must not hide the other events
+			page.Invalid = append(page.Invalid, InvalidEvent{
+				ID:    ev.ID,
+				Name:  ev.Summary,
+				Date:  ev.Start,
+				Field: descErr.Field,
+				Error: descErr.Reason,
+			})
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
-		retEvents = append(retEvents, e)
+		if filter.Level != "" && e.Level != filter.Level {
+			continue
+		}
+		page.Events = append(page.Events, e)
 	}
-	return retEvents, nil
+	return page, nil
 }
 
-func (c *Client) GetEvent(ctx context.Context, date string) (*calendar.Event, error) {
-	dateParsed, err := time.Parse(model.DateLayout, date)
+func (c *Client) GetEvent(ctx context.Context, eventID string) (*Event, error) {
+	event, _, err := c.getStoredEvent(ctx, eventID)
 	if err != nil {
-		c.Logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "could not parse time",
-				"error":   err,
-			}},
-		)
 		return nil, err
 	}
+	return c.ToEvent(event)
+}
 
-	event, err := c.Service.Events.List(c.CalendarID).
-		ShowDeleted(false).
-		SingleEvents(true).
-		TimeMin(dateParsed.Format(time.RFC3339)).
-		TimeMax(dateParsed.Add(24 * time.Hour).Format(time.RFC3339)).
-		MaxResults(10).
-		Do()
+// findEvent looks an event up by its ID. Date-only IDs from before events
+// had their own ID are still accepted when only one session exists that day.
+func (c *Client) findEvent(ctx context.Context, eventID string) (*StoredEvent, error) {
+	dateParsed, err := time.Parse(model.DateLayout, eventID)
 	if err != nil {
+		return c.Store.GetEvent(ctx, eventID)
+	}
+
+	events, _, err := c.Store.ListEvents(ctx, EventQuery{
+		From:  dateParsed,
+		To:    dateParsed.Add(24 * time.Hour),
+		Limit: 10,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(events) {
+	case 0:
+		return nil, ErrEventNotFound
+	case 1:
+		return events[0], nil
+	default:
+		ambiguous := &AmbiguousEventError{Date: eventID, EventIDs: []string{}}
+		for _, event := range events {
+			ambiguous.EventIDs = append(ambiguous.EventIDs, event.ID)
+		}
 		c.Logger.Log(logging.Entry{
-			Severity: logging.Error,
+			Severity: logging.Info,
 			Payload: map[string]interface{}{
-				"message": "could not list events from google",
-				"error":   err,
+				"message": "several events on the same date",
+				"date":    eventID,
+				"events":  ambiguous.EventIDs,
 			}},
 		)
-		return nil, err
+		return nil, ambiguous
 	}
+}
 
-	for _, e := range event.Items {
-		return e, nil
-	}
+// AmbiguousEventError lists the events on the date used as an event ID, so
+// the client can pick one.
+type AmbiguousEventError struct {
+	Date     string   `json:"date"`
+	EventIDs []string `json:"event_ids"`
+}
+
+func (e *AmbiguousEventError) Error() string {
+	return ErrAmbiguousEvent.Error()
+}
 
-	return nil, err
+// Is keeps errors.Is(err, ErrAmbiguousEvent) working.
+func (e *AmbiguousEventError) Is(target error) bool {
+	return target == ErrAmbiguousEvent
 }
 
-func (c *Client) GetSingleEvent(ctx context.Context, eventDate string, userInfo *spreadsheet.User) (*calendar.Event, *Description, error) {
-	oldEvent, err := c.GetEvent(ctx, eventDate)
+func (c *Client) getStoredEvent(ctx context.Context, eventID string) (*StoredEvent, *Description, error) {
+	oldEvent, err := c.findEvent(ctx, eventID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	description, err := readDescription(oldEvent.Description)
+	description, err := readDescription(oldEvent)
 	if err != nil {
 		c.Logger.Log(logging.Entry{
 			Severity: logging.Error,
@@ -218,125 +411,189 @@ func (c *Client) GetSingleEvent(ctx context.Context, eventDate string, userInfo
 	return oldEvent, description, nil
 }
 
-func (c *Client) AddAttendeeEvent(ctx context.Context, eventDate string, payment *Payment, userInfo *spreadsheet.User) (*Event, error) {
-	oldEvent, description, err := c.GetSingleEvent(ctx, eventDate, userInfo)
-	if err != nil {
-		return nil, err
-	}
+func (c *Client) AddAttendeeEvent(ctx context.Context, eventID string, payment *Payment, userInfo *spreadsheet.User) (*Event, error) {
+	return c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		if description.Cancelled {
+			return ErrEventCancelled
+		}
 
-	if userInfo.Level < model.StringToLevel(description.Level) {
-		return nil, errors.New("user has no compatible level")
-	}
+		if userInfo.Level < model.StringToLevel(description.Level) {
+			return errors.New("user has no compatible level")
+		}
+
+		c.Logger.Log(logging.Entry{
+			Severity: logging.Info,
+			Payload: map[string]interface{}{
+				"message":  "updating event",
+				"event":    oldEvent,
+				"attendes": userInfo,
+			}},
+		)
 
-	c.Logger.Log(logging.Entry{
-		Severity: logging.Info,
-		Payload: map[string]interface{}{
-			"message":  "updating event",
-			"event":    oldEvent,
-			"attendes": userInfo,
-		}},
-	)
+		// a member who already signed up can still pay afterwards
+		payNow := payment != nil && !description.Payments.HasUserPaid(userInfo.Email)
+		if !description.addAttendee(userInfo) && !payNow {
+			return errNoChange
+		}
 
-	for index := range description.Attendees {
-		if description.Attendees[index].Name == userInfo.Name && description.Attendees[index].Email == userInfo.Email {
-			return c.GoogleEventToEvent(oldEvent)
+		if payNow {
+			description.Payments = append(description.Payments, *payment)
 		}
-	}
-	description.Attendees = append(description.Attendees, Attendee{
-		Name:     userInfo.Name,
-		Email:    userInfo.Email,
-		SignTime: time.Now(),
+		return nil
 	})
+}
 
-	if payment != nil {
-		description.Payments = append(description.Payments, *payment)
-	}
-
-	oldEvent.Description = description.String()
-	newEvent, err := c.Service.Events.Update(c.CalendarID, oldEvent.Id, oldEvent).
-		Do()
-	if err != nil {
+// RemoveAttendee signs userInfo off. Members promoted from the waitlist
+// to the freed spot are notified. A card payment is refunded when the
+// payment removal deadline has not passed.
+func (c *Client) RemoveAttendee(ctx context.Context, eventID string, userInfo *spreadsheet.User) (*Event, error) {
+	var refund *Payment
+	var promoted []Attendee
+	event, err := c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		refund, promoted = nil, nil
 		c.Logger.Log(logging.Entry{
-			Severity: logging.Error,
+			Severity: logging.Info,
 			Payload: map[string]interface{}{
-				"message": "failed to update event",
-				"error":   err,
+				"message":  "removing attendee",
+				"event":    oldEvent,
+				"attendes": userInfo,
 			}},
 		)
 
-		return nil, err
-	}
-	return c.GoogleEventToEvent(newEvent)
-}
+		if userInfo.Level < model.StringToLevel(description.Level) {
+			return errors.New("user has no compatible level")
+		}
 
-func (c *Client) RemoveAttendee(ctx context.Context, eventDate string, userInfo *spreadsheet.User) (*Event, error) {
-	oldEvent, description, err := c.GetSingleEvent(ctx, eventDate, userInfo)
-	if err != nil {
-		return nil, err
-	}
+		if index := attendeeIndex(description.Attendees, userInfo.Email); index >= 0 {
+			late, err := description.policy().checkSignOff(oldEvent.Start, time.Now(), description.Attendees[index])
+			if err != nil {
+				return err
+			}
+			if late != nil {
+				description.LateCancellations = append(description.LateCancellations, *late)
+			}
 
-	c.Logger.Log(logging.Entry{
-		Severity: logging.Info,
-		Payload: map[string]interface{}{
-			"message":  "removing attendee",
-			"event":    oldEvent,
-			"attendes": userInfo,
-		}},
-	)
+			description.Attendees = append(description.Attendees[:index], description.Attendees[index+1:]...)
 
-	if userInfo.Level < model.StringToLevel(description.Level) {
-		return nil, errors.New("user has no compatible level")
-	}
+			promoted = description.promoteWaitlist()
+			if len(promoted) > 0 {
+				c.Logger.Log(logging.Entry{
+					Severity: logging.Info,
+					Payload: map[string]interface{}{
+						"message":  "promoted from waitlist",
+						"event":    oldEvent.ID,
+						"attendes": promoted,
+					}},
+				)
+			}
+		} else if index := attendeeIndex(description.Waitlist, userInfo.Email); index >= 0 {
+			description.Waitlist = append(description.Waitlist[:index], description.Waitlist[index+1:]...)
+		}
 
-	for index := range description.Attendees {
-		if strings.EqualFold(description.Attendees[index].Email, userInfo.Email) {
-			description.Attendees = append(description.Attendees[:index], description.Attendees[index+1:]...)
-			break
+		if index := description.cardPaymentIndex(userInfo.Email); index >= 0 {
+			if description.policy().checkPaymentRemoval(oldEvent.Start, time.Now()) == nil {
+				refund = description.claimRefund(index)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return event, err
+	}
+
+	for _, attendee := range promoted {
+		c.notifyPromoted(ctx, event, attendee)
+	}
+	if refund == nil {
+		return event, nil
 	}
+	return c.issueRefund(ctx, event, refund), nil
+}
 
-	oldEvent.Description = description.String()
-	newEvent, err := c.Service.Events.
-		Update(c.CalendarID, oldEvent.Id, oldEvent).
-		Do()
+// notifyPromoted tells attendee they got a spot. A failed notification
+// does not undo the promotion.
+func (c *Client) notifyPromoted(ctx context.Context, event *Event, attendee Attendee) {
+	err := c.Notifier.Notify(ctx, promotionMessage(event, attendee))
 	if err != nil {
 		c.Logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
-				"message": "failed to update event",
+				"message": "could not notify promoted attendee",
+				"event":   event.ID,
+				"user":    attendee.Email,
 				"error":   err,
 			}},
 		)
-		return nil, err
 	}
-	return c.GoogleEventToEvent(newEvent)
+}
+
+func promotionMessage(event *Event, attendee Attendee) notify.Message {
+	date := event.Date.Format(model.DateLayout)
+	body := fmt.Sprintf("Hi %s,\n\na spot opened up on %q on %s at %s and you are now signed up.\n",
+		attendee.Name, event.Name, date, event.Date.Format("15:04"))
+	body += "\nIf you cannot come, please sign off so the next person on the waitlist gets the spot.\n"
+
+	return notify.Message{
+		To:      attendee.Email,
+		Name:    attendee.Name,
+		Subject: fmt.Sprintf("You are signed up: %s %s", event.Name, date),
+		Body:    body,
+	}
+}
+
+// Of returns the payments made by email.
+func (p Payments) Of(email string) Payments {
+	payments := Payments{}
+	for _, payment := range p {
+		if email != "" && strings.EqualFold(email, payment.Email) {
+			payments = append(payments, payment)
+		}
+	}
+	return payments
 }
 
 func (p Payments) HasUserPaid(email string) bool {
 	for _, payment := range p {
-		if strings.EqualFold(email, payment.Email) {
+		if strings.EqualFold(email, payment.Email) && payment.IsPaid() {
 			return true
 		}
 	}
 	return false
 }
 
-func (c *Client) UpdateEvent(ctx context.Context, eventDate string, userInfo *spreadsheet.User) (*Event, error) {
-	oldEvent, description, err := c.GetSingleEvent(ctx, eventDate, userInfo)
-	if err != nil {
-		return nil, err
-	}
+// UpdateEvent toggles the payment of userInfo. Removing a card payment
+// refunds it. Only attendees can claim a payment, and not again after the
+// treasurer rejected their claim.
+func (c *Client) UpdateEvent(ctx context.Context, eventID string, userInfo *spreadsheet.User) (*Event, error) {
+	var refund *Payment
+	event, err := c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		refund = nil
+		if description.Cancelled {
+			return ErrEventCancelled
+		}
 
-	index, hasPayment := description.UserHasPayment(userInfo.Email)
+		index, hasPayment := description.UserHasPayment(userInfo.Email)
+
+		if !hasPayment {
+			if attendeeIndex(description.Attendees, userInfo.Email) < 0 {
+				return ErrNotAttending
+			}
+			for _, payment := range description.Payments.Of(userInfo.Email) {
+				if payment.Status == PaymentRejected {
+					return ErrClaimRejected
+				}
+			}
+			description.Payments = append(description.Payments, Payment{
+				Email:         userInfo.Email,
+				PaidTimestamp: time.Now(),
+				Method:        MethodSwish,
+				Status:        PaymentClaimed,
+				Amount:        int64(description.Price) * 100,
+			})
+			return nil
+		}
 
-	if !hasPayment {
-		description.Payments = append(description.Payments, Payment{
-			Email:         userInfo.Email,
-			PaidTimestamp: time.Now(),
-		})
-	} else {
-		eventDate, err := time.Parse("2006-01-02", eventDate)
-		if err != nil {
+		if err := description.policy().checkPaymentRemoval(oldEvent.Start, time.Now()); err != nil {
 			c.Logger.Log(logging.Entry{
 				Severity: logging.Error,
 				Payload: map[string]interface{}{
@@ -344,41 +601,77 @@ func (c *Client) UpdateEvent(ctx context.Context, eventDate string, userInfo *sp
 					"error":   err,
 				}},
 			)
+			return err
+		}
+		if description.Payments[index].PaymentIntent != "" {
+			refund = description.claimRefund(index)
+			return nil
+		}
+		if description.Payments[index].Status == PaymentConfirmed {
+			return ErrPaymentConfirmed
+		}
+		description.Payments = append(description.Payments[:index], description.Payments[index+1:]...)
+		return nil
+	})
+	if err != nil || refund == nil {
+		return event, err
+	}
+	return c.issueRefund(ctx, event, refund), nil
+}
+
+// modifyEvent runs a read-modify-write cycle on the event description.
+// The store only accepts the write if the event is unchanged since it was
+// read, otherwise the whole cycle is retried with a fresh copy of the event
+// after a short backoff.
+func (c *Client) modifyEvent(ctx context.Context, eventID string, change func(*StoredEvent, *Description) error) (*Event, error) {
+	backoff := updateBackoff
+	for attempt := 1; ; attempt++ {
+		oldEvent, description, err := c.getStoredEvent(ctx, eventID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = change(oldEvent, description)
+		if errors.Is(err, errNoChange) {
+			return c.ToEvent(oldEvent)
+		}
+		if err != nil {
 			return nil, err
 		}
 
-		// cannot remove payment 2 days before the event
-		if time.Now().Add(time.Hour * 48).After(eventDate) {
+		oldEvent.Description = description.String()
+		newEvent, err := c.Store.UpdateEvent(ctx, oldEvent)
+		if err == nil {
+			return c.ToEvent(newEvent)
+		}
+
+		if !errors.Is(err, ErrConflict) || attempt >= maxUpdateAttempts {
 			c.Logger.Log(logging.Entry{
 				Severity: logging.Error,
 				Payload: map[string]interface{}{
-					"message": "failed to update event",
-					"error":   ErrCannotRemovePayment,
+					"message":  "failed to update event",
+					"attempts": attempt,
+					"error":    err,
 				}},
 			)
-			return nil, ErrCannotRemovePayment
+			if errors.Is(err, ErrConflict) {
+				return nil, ErrConcurrentUpdate
+			}
+			return nil, err
 		}
-		description.Payments = append(description.Payments[:index], description.Payments[index+1:]...)
-	}
 
-	oldEvent.Description = description.String()
-	newEvent, err := c.Service.Events.Update(c.CalendarID, oldEvent.Id, oldEvent).Do()
-	if err != nil {
-		c.Logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "failed to update event",
-				"error":   err,
-			}},
-		)
-		return nil, err
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))):
+		}
+		backoff *= 2
 	}
-	return c.GoogleEventToEvent(newEvent)
 }
 
 func (d *Description) UserHasPayment(email string) (int, bool) {
 	for index, payment := range d.Payments {
-		if strings.EqualFold(email, payment.Email) {
+		if strings.EqualFold(email, payment.Email) && payment.IsPaid() {
 			return index, true
 		}
 	}
@@ -0,0 +1,403 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// memoryStore keeps events in memory and, like Google Calendar, refuses
+// updates of events that changed since they were read.
+type memoryStore struct {
+	mu     sync.Mutex
+	events map[string]StoredEvent
+	// readDelay widens the window between reading and writing an event,
+	// so concurrent updates overlap
+	readDelay time.Duration
+	// alwaysConflict makes every update fail
+	alwaysConflict bool
+	conflicts      int
+	created        int
+}
+
+func newMemoryStore(events ...StoredEvent) *memoryStore {
+	store := &memoryStore{events: map[string]StoredEvent{}}
+	for _, event := range events {
+		event.ETag = "1"
+		store.events[event.ID] = event
+	}
+	return store
+}
+
+func (s *memoryStore) ListEvents(ctx context.Context, query EventQuery) ([]*StoredEvent, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []*StoredEvent{}
+	for _, event := range s.events {
+		event := event
+		if event.Start.Before(query.From) || (!query.To.IsZero() && !event.Start.Before(query.To)) {
+			continue
+		}
+		events = append(events, &event)
+	}
+	return events, "", nil
+}
+
+func (s *memoryStore) GetEvent(ctx context.Context, id string) (*StoredEvent, error) {
+	s.mu.Lock()
+	event, found := s.events[id]
+	s.mu.Unlock()
+
+	if !found {
+		return nil, ErrEventNotFound
+	}
+	time.Sleep(s.readDelay)
+	return &event, nil
+}
+
+func (s *memoryStore) CreateEvent(ctx context.Context, event *StoredEvent) (*StoredEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.created++
+	created := *event
+	created.ID = fmt.Sprintf("created%d", s.created)
+	created.ETag = "1"
+	s.events[created.ID] = created
+	return &created, nil
+}
+
+func (s *memoryStore) UpdateEvent(ctx context.Context, event *StoredEvent) (*StoredEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, found := s.events[event.ID]
+	if !found {
+		return nil, ErrEventNotFound
+	}
+	if s.alwaysConflict || stored.ETag != event.ETag {
+		s.conflicts++
+		return nil, ErrConflict
+	}
+
+	etag, _ := strconv.Atoi(stored.ETag)
+	updated := *event
+	updated.ETag = strconv.Itoa(etag + 1)
+	s.events[event.ID] = updated
+	return &updated, nil
+}
+
+func (s *memoryStore) DeleteEvent(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.events[id]; !found {
+		return ErrEventNotFound
+	}
+	delete(s.events, id)
+	return nil
+}
+
+func (s *memoryStore) ListSeries(ctx context.Context) ([]*Series, error) {
+	return nil, nil
+}
+
+func (s *memoryStore) GetSeries(ctx context.Context, id string) (*Series, error) {
+	return nil, ErrSeriesNotFound
+}
+
+func (s *memoryStore) SaveSeries(ctx context.Context, series *Series) (*Series, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *memoryStore) DeleteSeries(ctx context.Context, id string) error {
+	return errors.New("not implemented")
+}
+
+// testLogger logs nowhere. Entries are buffered and never delivered.
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	client, err := logging.NewClient(context.Background(), "projects/test",
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client.Logger("test")
+}
+
+func newTestClient(t *testing.T, store EventStore) *Client {
+	logger := testLogger(t)
+	return New(store, logger, notify.NewLogNotifier(logger), nil)
+}
+
+func testEvent(description Description) StoredEvent {
+	description.Version = CurrentDescriptionVersion
+	start := time.Now().Add(7 * 24 * time.Hour)
+	return StoredEvent{
+		ID:          "event",
+		Summary:     "Training",
+		Start:       start,
+		End:         start.Add(2 * time.Hour),
+		Description: description.String(),
+	}
+}
+
+func TestAddAttendeeEventKeepsConcurrentSignUps(t *testing.T) {
+	// every failed attempt means another sign-up was written, so with no
+	// more sign-ups than attempts none of them can run out of retries
+	signUps := maxUpdateAttempts
+	store := newMemoryStore(testEvent(Description{MaxParticipants: signUps}))
+	store.readDelay = 10 * time.Millisecond
+	client := newTestClient(t, store)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, signUps)
+	for i := 0; i < signUps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := client.AddAttendeeEvent(context.Background(), "event", nil, &spreadsheet.User{
+				Name:  fmt.Sprintf("Member %d", i),
+				Email: fmt.Sprintf("member%d@example.com", i),
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("sign-up failed: %v", err)
+		}
+	}
+
+	event, err := client.GetEvent(context.Background(), "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Attendees) != signUps {
+		t.Errorf("got %d attendees, want %d", len(event.Attendees), signUps)
+	}
+	for i := 0; i < signUps; i++ {
+		if attendeeIndex(event.Attendees, fmt.Sprintf("member%d@example.com", i)) < 0 {
+			t.Errorf("member%d is not attending", i)
+		}
+	}
+	if store.conflicts == 0 {
+		t.Error("sign-ups never conflicted, the retries were not exercised")
+	}
+}
+
+func TestAddAttendeeEventGivesUpAfterRetries(t *testing.T) {
+	store := newMemoryStore(testEvent(Description{}))
+	store.alwaysConflict = true
+	client := newTestClient(t, store)
+
+	_, err := client.AddAttendeeEvent(context.Background(), "event", nil, &spreadsheet.User{
+		Name:  "Member",
+		Email: "member@example.com",
+	})
+	if !errors.Is(err, ErrConcurrentUpdate) {
+		t.Fatalf("got %v, want ErrConcurrentUpdate", err)
+	}
+	if store.conflicts != maxUpdateAttempts {
+		t.Errorf("got %d attempts, want %d", store.conflicts, maxUpdateAttempts)
+	}
+}
+
+func TestRecordPaymentSignsUpOnlyPaidPayments(t *testing.T) {
+	store := newMemoryStore(testEvent(Description{Price: 100}))
+	client := newTestClient(t, store)
+	member := &spreadsheet.User{Name: "Member", Email: "member@example.com"}
+	ctx := context.Background()
+
+	pending := PaymentUpdate{Reference: "request", Method: MethodSwish, Status: PaymentPending, Amount: 10000}
+	event, err := client.RecordPayment(ctx, "event", member, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Attendees) != 0 {
+		t.Fatalf("a pending payment signed the member up: %+v", event.Attendees)
+	}
+
+	failed := pending
+	failed.Status = PaymentFailed
+	event, err = client.RecordPayment(ctx, "event", nil, failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Attendees) != 0 || event.PaymentStatusOf(member.Email) != PaymentFailed {
+		t.Fatalf("got attendees %+v and status %q after a failed payment", event.Attendees, event.PaymentStatusOf(member.Email))
+	}
+
+	retried := PaymentUpdate{Reference: "retry", Method: MethodSwish, Status: PaymentPending, Amount: 10000}
+	if _, err := client.RecordPayment(ctx, "event", member, retried); err != nil {
+		t.Fatal(err)
+	}
+	retried.Status = PaymentConfirmed
+	event, err = client.RecordPayment(ctx, "event", nil, retried)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendeeIndex(event.Attendees, member.Email) < 0 {
+		t.Fatalf("a confirmed payment did not sign the member up: %+v", event.Attendees)
+	}
+}
+
+func TestLevelIgnoresCase(t *testing.T) {
+	event := testEvent(Description{MaxParticipants: 10})
+	event.Description = fmt.Sprintf("version: %d\nlevel: advanced\nmax_participants: 10\n", CurrentDescriptionVersion)
+	client := newTestClient(t, newMemoryStore(event))
+
+	stored, err := client.GetEvent(context.Background(), "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Level != model.Advanced.String() {
+		t.Errorf("got level %q, want %q", stored.Level, model.Advanced)
+	}
+
+	_, err = client.AddAttendeeEvent(context.Background(), "event", nil, &spreadsheet.User{
+		Name:  "Beginner",
+		Email: "beginner@example.com",
+		Level: model.Basic,
+	})
+	if err == nil {
+		t.Fatal("a basic member signed up for an advanced session")
+	}
+
+	_, err = client.AddAttendeeEvent(context.Background(), "event", nil, &spreadsheet.User{
+		Name:  "Advanced",
+		Email: "advanced@example.com",
+		Level: model.Advanced,
+	})
+	if err != nil {
+		t.Fatalf("an advanced member could not sign up: %v", err)
+	}
+}
+
+func TestFindEvent(t *testing.T) {
+	at := func(date string, hour int) time.Time {
+		t, _ := time.Parse(model.DateLayout, date)
+		return t.Add(time.Duration(hour) * time.Hour)
+	}
+	event := func(id string, start time.Time) StoredEvent {
+		event := testEvent(Description{})
+		event.ID, event.Start, event.End = id, start, start.Add(2*time.Hour)
+		return event
+	}
+	client := newTestClient(t, newMemoryStore(
+		event("single", at("2024-01-09", 18)),
+		event("morning", at("2024-01-10", 10)),
+		event("evening", at("2024-01-10", 18)),
+		event("next day", at("2024-01-11", 0)),
+	))
+
+	tests := []struct {
+		id        string
+		want      string
+		ambiguous []string
+		err       error
+	}{
+		{id: "single", want: "single"},
+		{id: "2024-01-09", want: "single"},
+		{id: "2024-01-10", ambiguous: []string{"evening", "morning"}, err: ErrAmbiguousEvent},
+		{id: "2024-01-12", err: ErrEventNotFound},
+		{id: "missing", err: ErrEventNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.id, func(t *testing.T) {
+			event, err := client.findEvent(context.Background(), test.id)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if test.err != nil {
+				var ambiguousErr *AmbiguousEventError
+				if errors.As(err, &ambiguousErr) {
+					sort.Strings(ambiguousErr.EventIDs)
+					if ambiguousErr.Date != test.id || !reflect.DeepEqual(ambiguousErr.EventIDs, test.ambiguous) {
+						t.Errorf("got %+v, want events %v", ambiguousErr, test.ambiguous)
+					}
+				} else if test.ambiguous != nil {
+					t.Errorf("got %v, want an AmbiguousEventError", err)
+				}
+				return
+			}
+			if event.ID != test.want {
+				t.Errorf("got %s, want %s", event.ID, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveAttendeePromotesWaitlist(t *testing.T) {
+	leaving := &spreadsheet.User{Name: "Leaving", Email: "leaving@example.com"}
+	first := Attendee{Name: "First", Email: "first@example.com"}
+	second := Attendee{Name: "Second", Email: "second@example.com"}
+	store := newMemoryStore(testEvent(Description{
+		MaxParticipants: 1,
+		Attendees:       []Attendee{{Name: leaving.Name, Email: leaving.Email, SignTime: time.Now()}},
+		Waitlist:        []Attendee{first, second},
+	}))
+	client, notifier := newClaimClient(t, store)
+
+	event, err := client.RemoveAttendee(context.Background(), "event", leaving)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Attendees) != 1 || event.Attendees[0].Email != first.Email {
+		t.Errorf("got attendees %+v, want the first on the waitlist", event.Attendees)
+	}
+	if len(event.Waitlist) != 1 || event.Waitlist[0].Email != second.Email {
+		t.Errorf("got waitlist %+v, want the second still waiting", event.Waitlist)
+	}
+
+	if len(notifier.messages) != 1 {
+		t.Fatalf("got messages %+v, want the promotion", notifier.messages)
+	}
+	message := notifier.messages[0]
+	if message.To != first.Email || message.Name != first.Name ||
+		!strings.HasPrefix(message.Subject, "You are signed up: Training") ||
+		!strings.Contains(message.Body, "Hi First,") {
+		t.Errorf("got promotion %+v", message)
+	}
+}
+
+func TestRemoveAttendeePromotesDespiteNotifyError(t *testing.T) {
+	leaving := &spreadsheet.User{Name: "Leaving", Email: "leaving@example.com"}
+	store := newMemoryStore(testEvent(Description{
+		MaxParticipants: 1,
+		Attendees:       []Attendee{{Name: leaving.Name, Email: leaving.Email, SignTime: time.Now()}},
+		Waitlist:        []Attendee{{Name: "First", Email: "first@example.com"}},
+	}))
+	client, notifier := newClaimClient(t, store)
+	notifier.err = errors.New("mail server down")
+
+	event, err := client.RemoveAttendee(context.Background(), "event", leaving)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendeeIndex(event.Attendees, "first@example.com") < 0 || len(event.Waitlist) != 0 {
+		t.Errorf("got attendees %+v, waitlist %+v, want the first promoted", event.Attendees, event.Waitlist)
+	}
+}
@@ -0,0 +1,325 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"gopkg.in/yaml.v2"
+)
+
+// GoogleStore keeps events in a Google Calendar, with the booking state
+// as YAML in the event description.
+type GoogleStore struct {
+	Service    *calendar.Service
+	CalendarID string
+	Logger     *logging.Logger
+}
+
+func NewGoogleStore(serviceAccount string, calendarID string, logger *logging.Logger) (*GoogleStore, error) {
+	service, err := getClient(serviceAccount, logger)
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "unable to retrieve Calendar client",
+				"error":   err,
+			}},
+		)
+		return nil, err
+	}
+
+	return &GoogleStore{
+		CalendarID: calendarID,
+		Service:    service,
+		Logger:     logger,
+	}, nil
+}
+
+func getClient(serviceAccount string, logger *logging.Logger) (*calendar.Service, error) {
+	ctx := context.Background()
+	credentials, err := google.CredentialsFromJSON(ctx, []byte(serviceAccount), calendar.CalendarEventsScope)
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "unable read credentials",
+				"error":   err,
+			}},
+		)
+		return nil, err
+	}
+
+	srv, err := calendar.NewService(ctx, option.WithCredentials(credentials))
+	if err != nil {
+		logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "unable authenticate to Calendar API",
+				"error":   err,
+			}},
+		)
+		return nil, err
+	}
+
+	return srv, err
+}
+
+func (g *GoogleStore) GetCalendars() (*calendar.CalendarList, error) {
+	return g.Service.CalendarList.List().Do()
+}
+
+func (g *GoogleStore) ListEvents(ctx context.Context, query EventQuery) ([]*StoredEvent, string, error) {
+	call := g.Service.Events.List(g.CalendarID).
+		Context(ctx).
+		ShowDeleted(false).
+		SingleEvents(true).
+		TimeMin(query.From.Format(time.RFC3339)).
+		MaxResults(int64(query.Limit)).
+		OrderBy("startTime")
+	if !query.To.IsZero() {
+		call = call.TimeMax(query.To.Format(time.RFC3339))
+	}
+	if query.PageToken != "" {
+		call = call.PageToken(query.PageToken)
+	}
+
+	events, err := call.Do()
+	if query.PageToken != "" && hasStatus(err, http.StatusBadRequest, http.StatusGone) {
+		return nil, "", ErrInvalidPageToken
+	}
+	if err != nil {
+		g.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not list events from google",
+				"error":   err,
+			}},
+		)
+		return nil, "", err
+	}
+
+	retEvents := []*StoredEvent{}
+	for _, ev := range events.Items {
+		if isSeries(ev) {
+			continue
+		}
+		retEvents = append(retEvents, fromGoogleEvent(ev))
+	}
+	return retEvents, events.NextPageToken, nil
+}
+
+func (g *GoogleStore) GetEvent(ctx context.Context, id string) (*StoredEvent, error) {
+	event, err := g.Service.Events.Get(g.CalendarID, id).Context(ctx).Do()
+	if err != nil {
+		if hasStatus(err, http.StatusNotFound, http.StatusGone) {
+			return nil, ErrEventNotFound
+		}
+		return nil, err
+	}
+	if event.Status == "cancelled" || isSeries(event) {
+		return nil, ErrEventNotFound
+	}
+	return fromGoogleEvent(event), nil
+}
+
+func (g *GoogleStore) CreateEvent(ctx context.Context, event *StoredEvent) (*StoredEvent, error) {
+	newEvent, err := g.Service.Events.Insert(g.CalendarID, toGoogleEvent(event)).Context(ctx).Do()
+	if err != nil {
+		g.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "failed to create event",
+				"error":   err,
+			}},
+		)
+		return nil, err
+	}
+	return fromGoogleEvent(newEvent), nil
+}
+
+// UpdateEvent patches the fields owned by the booking service and sends the
+// event ETag as an If-Match precondition, so Google rejects the write when
+// someone else changed the event in between.
+func (g *GoogleStore) UpdateEvent(ctx context.Context, event *StoredEvent) (*StoredEvent, error) {
+	gEvent := toGoogleEvent(event)
+	gEvent.ForceSendFields = []string{"Summary", "Location", "Description"}
+	call := g.Service.Events.Patch(g.CalendarID, event.ID, gEvent).Context(ctx)
+	call.Header().Set("If-Match", event.ETag)
+	newEvent, err := call.Do()
+	if err != nil {
+		if hasStatus(err, http.StatusPreconditionFailed, http.StatusConflict) {
+			return nil, ErrConflict
+		}
+		return nil, err
+	}
+	return fromGoogleEvent(newEvent), nil
+}
+
+func (g *GoogleStore) DeleteEvent(ctx context.Context, id string) error {
+	err := g.Service.Events.Delete(g.CalendarID, id).Context(ctx).Do()
+	if hasStatus(err, http.StatusNotFound, http.StatusGone) {
+		return ErrEventNotFound
+	}
+	return err
+}
+
+func hasStatus(err error, codes ...int) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func fromGoogleEvent(gEvent *calendar.Event) *StoredEvent {
+	return &StoredEvent{
+		ID:          gEvent.Id,
+		ETag:        gEvent.Etag,
+		Summary:     gEvent.Summary,
+		Location:    gEvent.Location,
+		Start:       fromEventDateTime(gEvent.Start),
+		End:         fromEventDateTime(gEvent.End),
+		Description: gEvent.Description,
+	}
+}
+
+func fromEventDateTime(dateTime *calendar.EventDateTime) time.Time {
+	if dateTime == nil {
+		return time.Time{}
+	}
+	if t := model.TimeParse(dateTime.DateTime); t != nil {
+		return *t
+	}
+	t, _ := time.Parse(model.DateLayout, dateTime.Date)
+	return t
+}
+
+func toGoogleEvent(event *StoredEvent) *calendar.Event {
+	return &calendar.Event{
+		Id:          event.ID,
+		Etag:        event.ETag,
+		Summary:     event.Summary,
+		Location:    event.Location,
+		Description: event.Description,
+		Start:       &calendar.EventDateTime{DateTime: event.Start.Format(time.RFC3339)},
+		End:         &calendar.EventDateTime{DateTime: event.End.Format(time.RFC3339)},
+	}
+}
+
+// Series definitions are kept as private all-day events, marked with an
+// extended property so they are never listed as sessions.
+const seriesProperty = "booking_series"
+
+func isSeries(gEvent *calendar.Event) bool {
+	return gEvent.ExtendedProperties != nil && gEvent.ExtendedProperties.Private[seriesProperty] != ""
+}
+
+func (g *GoogleStore) ListSeries(ctx context.Context) ([]*Series, error) {
+	series := []*Series{}
+	err := g.Service.Events.List(g.CalendarID).
+		Context(ctx).
+		ShowDeleted(false).
+		PrivateExtendedProperty(seriesProperty+"=true").
+		Pages(ctx, func(events *calendar.Events) error {
+			for _, ev := range events.Items {
+				s, err := fromGoogleSeries(ev)
+				if err != nil {
+					return err
+				}
+				series = append(series, s)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func (g *GoogleStore) GetSeries(ctx context.Context, id string) (*Series, error) {
+	event, err := g.Service.Events.Get(g.CalendarID, id).Context(ctx).Do()
+	if err != nil {
+		if hasStatus(err, http.StatusNotFound, http.StatusGone) {
+			return nil, ErrSeriesNotFound
+		}
+		return nil, err
+	}
+	if event.Status == "cancelled" || !isSeries(event) {
+		return nil, ErrSeriesNotFound
+	}
+	return fromGoogleSeries(event)
+}
+
+func (g *GoogleStore) SaveSeries(ctx context.Context, series *Series) (*Series, error) {
+	gEvent, err := toGoogleSeries(series)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved *calendar.Event
+	if series.ID == "" {
+		saved, err = g.Service.Events.Insert(g.CalendarID, gEvent).Context(ctx).Do()
+	} else {
+		saved, err = g.Service.Events.Update(g.CalendarID, series.ID, gEvent).Context(ctx).Do()
+	}
+	if err != nil {
+		g.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "failed to save series",
+				"error":   err,
+			}},
+		)
+		return nil, err
+	}
+	return fromGoogleSeries(saved)
+}
+
+func (g *GoogleStore) DeleteSeries(ctx context.Context, id string) error {
+	err := g.Service.Events.Delete(g.CalendarID, id).Context(ctx).Do()
+	if hasStatus(err, http.StatusNotFound, http.StatusGone) {
+		return ErrSeriesNotFound
+	}
+	return err
+}
+
+func fromGoogleSeries(gEvent *calendar.Event) (*Series, error) {
+	series := &Series{}
+	if err := yaml.Unmarshal([]byte(gEvent.Description), series); err != nil {
+		return nil, err
+	}
+	series.ID = gEvent.Id
+	return series, nil
+}
+
+func toGoogleSeries(series *Series) (*calendar.Event, error) {
+	content, err := yaml.Marshal(series)
+	if err != nil {
+		return nil, err
+	}
+
+	return &calendar.Event{
+		Summary:      "Series: " + series.Title,
+		Description:  string(content),
+		Start:        &calendar.EventDateTime{Date: series.Start.Format(model.DateLayout)},
+		End:          &calendar.EventDateTime{Date: series.Start.AddDate(0, 0, 1).Format(model.DateLayout)},
+		Transparency: "transparent",
+		Visibility:   "private",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{seriesProperty: "true"},
+		},
+	}, nil
+}
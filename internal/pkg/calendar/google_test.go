@@ -0,0 +1,157 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	gcalendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// fakeCalendar serves the events endpoints of the Calendar API used by
+// GoogleStore, and answers like Google when the If-Match ETag is stale.
+type fakeCalendar struct {
+	mu      sync.Mutex
+	events  map[string]*gcalendar.Event
+	version int
+	// conflictStatus is returned instead of 412 for stale ETags
+	conflictStatus int
+	// interfere changes the event before the next patches, like another
+	// instance writing it in between
+	interfere int
+	patches   int
+}
+
+func newFakeCalendar(t *testing.T, events ...StoredEvent) (*fakeCalendar, *GoogleStore) {
+	fake := &fakeCalendar{events: map[string]*gcalendar.Event{}, conflictStatus: http.StatusPreconditionFailed}
+	for _, event := range events {
+		gEvent := toGoogleEvent(&event)
+		gEvent.Etag = fake.nextETag()
+		fake.events[event.ID] = gEvent
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	service, err := gcalendar.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, &GoogleStore{Service: service, CalendarID: "calendar", Logger: testLogger(t)}
+}
+
+func (f *fakeCalendar) nextETag() string {
+	f.version++
+	return fmt.Sprintf("%q", fmt.Sprint(f.version))
+}
+
+func (f *fakeCalendar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := strings.TrimPrefix(r.URL.Path, "/calendars/calendar/events/")
+	event, found := f.events[id]
+	if !found {
+		writeGoogleError(w, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		f.patches++
+		if f.interfere > 0 {
+			f.interfere--
+			event.Etag = f.nextETag()
+		}
+		if r.Header.Get("If-Match") != event.Etag {
+			writeGoogleError(w, f.conflictStatus)
+			return
+		}
+
+		patch := &gcalendar.Event{}
+		if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+			writeGoogleError(w, http.StatusBadRequest)
+			return
+		}
+		event.Summary = patch.Summary
+		event.Location = patch.Location
+		event.Description = patch.Description
+		event.Start = patch.Start
+		event.End = patch.End
+		event.Etag = f.nextETag()
+	default:
+		writeGoogleError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+func writeGoogleError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":%q}}`, status, http.StatusText(status))
+}
+
+func TestGoogleStoreUpdateEvent(t *testing.T) {
+	for _, status := range []int{http.StatusPreconditionFailed, http.StatusConflict} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			fake, store := newFakeCalendar(t, testEvent(Description{}))
+			fake.conflictStatus = status
+			ctx := context.Background()
+
+			event, err := store.GetEvent(ctx, "event")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stale := *event
+
+			event.Summary = "Updated"
+			updated, err := store.UpdateEvent(ctx, event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Summary != "Updated" || updated.ETag == event.ETag {
+				t.Errorf("got %+v after the update", updated)
+			}
+
+			stale.Summary = "Stale"
+			if _, err := store.UpdateEvent(ctx, &stale); !errors.Is(err, ErrConflict) {
+				t.Fatalf("update with a stale ETag: got %v, want ErrConflict", err)
+			}
+			if stored := fake.events["event"]; stored.Summary != "Updated" {
+				t.Errorf("the stale update was written: %q", stored.Summary)
+			}
+		})
+	}
+}
+
+func TestGoogleStoreRetriesConflicts(t *testing.T) {
+	fake, store := newFakeCalendar(t, testEvent(Description{MaxParticipants: 10}))
+	fake.interfere = 2
+	client := newTestClient(t, store)
+
+	event, err := client.AddAttendeeEvent(context.Background(), "event", nil, &spreadsheet.User{
+		Name:  "Member",
+		Email: "member@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendeeIndex(event.Attendees, "member@example.com") < 0 {
+		t.Errorf("member is not attending: %+v", event.Attendees)
+	}
+	if fake.patches != 3 {
+		t.Errorf("got %d patches, want 2 conflicts and a write", fake.patches)
+	}
+}
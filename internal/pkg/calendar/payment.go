@@ -0,0 +1,195 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+)
+
+// Payment methods.
+const (
+	MethodSwish  = "swish"
+	MethodStripe = "stripe"
+	MethodCash   = "cash"
+	MethodCredit = "credit"
+)
+
+// Payment statuses. Members claim the payments they make by hand until the
+// treasurer confirms them, card payments are confirmed by Stripe.
+const (
+	PaymentClaimed   = "claimed"
+	PaymentConfirmed = "confirmed"
+	PaymentRejected  = "rejected"
+	PaymentRefunded  = "refunded"
+	// statuses of card payments on their way to being confirmed
+	PaymentPending   = "pending"
+	PaymentUnderpaid = "underpaid"
+	PaymentFailed    = "failed"
+	PaymentExpired   = "expired"
+	// refunds issued by this server go from pending to refunded, or to
+	// failed while the money is still with the club
+	PaymentRefundPending = "refund_pending"
+	PaymentRefundFailed  = "refund_failed"
+)
+
+var (
+	ErrPaymentNotFound = errors.New("payment not found")
+	// ErrEventFull keeps members from paying for a spot that is not there.
+	ErrEventFull = errors.New("event is full")
+)
+
+// PaymentUpdate is a change of a payment reported by the payment provider.
+type PaymentUpdate struct {
+	Reference string
+	Method    string
+	Status    string
+	// Amount is in öre, Description.Price is in SEK.
+	Amount        int64
+	PaymentIntent string
+	Time          time.Time
+}
+
+func validPaymentMethod(method string) bool {
+	switch method {
+	case MethodSwish, MethodStripe, MethodCash, MethodCredit:
+		return true
+	}
+	return false
+}
+
+func validPaymentStatus(status string) bool {
+	switch status {
+	case PaymentClaimed, PaymentConfirmed, PaymentRejected, PaymentRefunded, PaymentPending, PaymentUnderpaid,
+		PaymentFailed, PaymentExpired, PaymentRefundPending, PaymentRefundFailed:
+		return true
+	}
+	return false
+}
+
+// IsPaid reports whether the payment covers the event. Claimed payments
+// count until the treasurer says otherwise.
+func (p Payment) IsPaid() bool {
+	switch p.Status {
+	case PaymentClaimed, PaymentConfirmed, PaymentRefundFailed:
+		return true
+	}
+	return false
+}
+
+// wentThrough reports whether money was taken, even if not enough.
+func wentThrough(status string) bool {
+	return status == PaymentConfirmed || status == PaymentUnderpaid
+}
+
+func (p Payments) paid() Payments {
+	paid := Payments{}
+	for _, payment := range p {
+		if payment.IsPaid() {
+			paid = append(paid, payment)
+		}
+	}
+	return paid
+}
+
+func (d *Description) paymentIndex(reference string) int {
+	for index := range d.Payments {
+		if d.Payments[index].Reference == reference {
+			return index
+		}
+	}
+	return -1
+}
+
+// RecordPayment stores the status of a payment made through a payment
+// provider. Pending payments hold no spot: userInfo is signed up once the
+// payment went through, and may be nil for updates that do not create or
+// complete a payment. Paid amounts below the event price are recorded as
+// underpaid.
+func (c *Client) RecordPayment(ctx context.Context, eventID string, userInfo *spreadsheet.User, update PaymentUpdate) (*Event, error) {
+	return c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		status := update.Status
+		if status == PaymentConfirmed && update.Amount < int64(description.Price)*100 {
+			c.Logger.Log(logging.Entry{
+				Severity: logging.Warning,
+				Payload: map[string]interface{}{
+					"message":   "payment is below the event price",
+					"event":     oldEvent.ID,
+					"reference": update.Reference,
+					"amount":    update.Amount,
+					"price":     description.Price,
+				}},
+			)
+			status = PaymentUnderpaid
+		}
+
+		if index := description.paymentIndex(update.Reference); index >= 0 {
+			payment := &description.Payments[index]
+			if payment.Status == status && payment.Amount == update.Amount {
+				return errNoChange
+			}
+			if wentThrough(status) && !wentThrough(payment.Status) && !payment.IsPaid() {
+				c.signUpPayer(oldEvent, description, userInfo, payment.Email)
+			}
+			payment.Status = status
+			payment.Amount = update.Amount
+			if update.PaymentIntent != "" {
+				payment.PaymentIntent = update.PaymentIntent
+			}
+			if status == PaymentConfirmed {
+				payment.PaidTimestamp = update.Time
+			}
+			return nil
+		}
+
+		switch status {
+		case PaymentPending, PaymentConfirmed, PaymentUnderpaid:
+		default:
+			// nothing was recorded for this payment, so there is nothing to
+			// mark as failed or refunded
+			return errNoChange
+		}
+		if userInfo == nil {
+			return ErrPaymentNotFound
+		}
+
+		if wentThrough(status) {
+			c.signUpPayer(oldEvent, description, userInfo, userInfo.Email)
+		}
+		description.Payments = append(description.Payments, Payment{
+			Email:         userInfo.Email,
+			PaidTimestamp: update.Time,
+			Method:        update.Method,
+			Status:        status,
+			Amount:        update.Amount,
+			Reference:     update.Reference,
+			PaymentIntent: update.PaymentIntent,
+		})
+		return nil
+	})
+}
+
+// signUpPayer signs up the member whose payment went through. Money was
+// taken even if the event got cancelled or filled up meanwhile, so the
+// payment is kept either way for the treasurer to refund.
+func (c *Client) signUpPayer(oldEvent *StoredEvent, description *Description, userInfo *spreadsheet.User, email string) {
+	if description.Cancelled {
+		return
+	}
+	if userInfo == nil {
+		userInfo = &spreadsheet.User{Name: email, Email: email}
+	}
+	description.addAttendee(userInfo)
+	if attendeeIndex(description.Waitlist, userInfo.Email) >= 0 {
+		c.Logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload: map[string]interface{}{
+				"message": "paid member is on the waitlist",
+				"event":   oldEvent.ID,
+				"user":    userInfo.Email,
+			}},
+		)
+	}
+}
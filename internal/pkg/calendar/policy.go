@@ -0,0 +1,138 @@
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LateCancellation says what happens when an attendee signs off after the
+// sign-off deadline.
+type LateCancellation string
+
+const (
+	LateBlock  LateCancellation = "block"
+	LateFee    LateCancellation = "fee"
+	LateStrike LateCancellation = "strike"
+)
+
+const (
+	DeadlineSignOff        = "sign_off"
+	DeadlinePaymentRemoval = "payment_removal"
+)
+
+// CancellationPolicy sets how long before the start attendees can still
+// sign off or take back a payment.
+type CancellationPolicy struct {
+	SignOffHours        int              `json:"sign_off_hours" yaml:"sign_off_hours"`
+	PaymentRemovalHours int              `json:"payment_removal_hours" yaml:"payment_removal_hours"`
+	Late                LateCancellation `json:"late" yaml:"late"`
+	// LateFee is charged in SEK, like the event price, when Late is fee.
+	LateFee int `json:"late_fee,omitempty" yaml:"late_fee,omitempty"`
+}
+
+// DefaultPolicy applies to events without a policy of their own: signing
+// off is always possible and payments cannot be removed during the last
+// two days.
+var DefaultPolicy = CancellationPolicy{
+	SignOffHours:        0,
+	PaymentRemovalHours: 48,
+	Late:                LateBlock,
+}
+
+// LateCancellationRecord keeps track of a sign-off after the deadline that
+// was allowed with a fee or a strike.
+type LateCancellationRecord struct {
+	Name   string           `json:"name" yaml:"name"`
+	Email  string           `json:"email" yaml:"email"`
+	Time   time.Time        `json:"time" yaml:"time"`
+	Action LateCancellation `json:"action" yaml:"action"`
+	Fee    int              `json:"fee,omitempty" yaml:"fee,omitempty"`
+}
+
+// DeadlineError tells the client which deadline was missed.
+type DeadlineError struct {
+	Deadline string    `json:"deadline"`
+	At       time.Time `json:"deadline_at"`
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("the %s deadline passed at %s",
+		strings.ReplaceAll(e.Deadline, "_", "-"), e.At.Format(time.RFC3339))
+}
+
+// Is keeps errors.Is(err, ErrCannotRemovePayment) working for callers
+// written before deadlines were configurable.
+func (e *DeadlineError) Is(target error) bool {
+	return target == ErrCannotRemovePayment && e.Deadline == DeadlinePaymentRemoval
+}
+
+func (p *CancellationPolicy) Validate() error {
+	if p.SignOffHours < 0 {
+		return &ValidationError{Field: "policy.sign_off_hours", Reason: "cannot be negative"}
+	}
+	if p.PaymentRemovalHours < 0 {
+		return &ValidationError{Field: "policy.payment_removal_hours", Reason: "cannot be negative"}
+	}
+	switch p.Late {
+	case LateBlock, LateStrike:
+		if p.LateFee != 0 {
+			return &ValidationError{Field: "policy.late_fee", Reason: "is only used with late fee"}
+		}
+	case LateFee:
+		if p.LateFee <= 0 {
+			return &ValidationError{Field: "policy.late_fee", Reason: "must be positive"}
+		}
+	default:
+		return &ValidationError{Field: "policy.late", Reason: fmt.Sprintf("must be %s, %s or %s", LateBlock, LateFee, LateStrike)}
+	}
+	return nil
+}
+
+// signOffDeadline and paymentRemovalDeadline return when the deadlines of
+// an event starting at start pass.
+func (p *CancellationPolicy) signOffDeadline(start time.Time) time.Time {
+	return start.Add(-time.Duration(p.SignOffHours) * time.Hour)
+}
+
+func (p *CancellationPolicy) paymentRemovalDeadline(start time.Time) time.Time {
+	return start.Add(-time.Duration(p.PaymentRemovalHours) * time.Hour)
+}
+
+// checkSignOff returns the record to keep for a late sign-off, nil when
+// signing off is still free, or a *DeadlineError when it is blocked.
+func (p *CancellationPolicy) checkSignOff(start time.Time, now time.Time, attendee Attendee) (*LateCancellationRecord, error) {
+	deadline := p.signOffDeadline(start)
+	if now.Before(deadline) {
+		return nil, nil
+	}
+
+	switch p.Late {
+	case LateFee, LateStrike:
+		return &LateCancellationRecord{
+			Name:   attendee.Name,
+			Email:  attendee.Email,
+			Time:   now,
+			Action: p.Late,
+			Fee:    p.LateFee,
+		}, nil
+	default:
+		return nil, &DeadlineError{Deadline: DeadlineSignOff, At: deadline}
+	}
+}
+
+func (p *CancellationPolicy) checkPaymentRemoval(start time.Time, now time.Time) error {
+	deadline := p.paymentRemovalDeadline(start)
+	if now.Before(deadline) {
+		return nil
+	}
+	return &DeadlineError{Deadline: DeadlinePaymentRemoval, At: deadline}
+}
+
+// policy returns the cancellation policy of the event.
+func (d *Description) policy() *CancellationPolicy {
+	if d.Policy == nil {
+		return &DefaultPolicy
+	}
+	return d.Policy
+}
@@ -0,0 +1,98 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+var ErrRefundsDisabled = errors.New("card refunds are not enabled")
+
+// Refunder returns card payments, payment.API implements it.
+type Refunder interface {
+	Refund(ctx context.Context, paymentIntentID string, amount int64, idempotencyKey string) (string, error)
+}
+
+// Refund tells the member whether their card payment was returned.
+type Refund struct {
+	Issued    bool   `json:"issued"`
+	Amount    int64  `json:"amount"`
+	Reference string `json:"reference,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// cardPaymentIndex returns the index of the card payment of email that can
+// be refunded, or -1.
+func (d *Description) cardPaymentIndex(email string) int {
+	for index, payment := range d.Payments {
+		if strings.EqualFold(payment.Email, email) && payment.Method == MethodStripe && payment.PaymentIntent != "" && payment.IsPaid() {
+			return index
+		}
+	}
+	return -1
+}
+
+// claimRefund marks the payment as being refunded, so a concurrent request
+// cannot refund it twice, and returns a copy of it.
+func (d *Description) claimRefund(index int) *Payment {
+	d.Payments[index].Status = PaymentRefundPending
+	claimed := d.Payments[index]
+	return &claimed
+}
+
+// issueRefund refunds a payment claimed with claimRefund and records the
+// outcome on the event. The idempotency key is derived from the payment,
+// so retrying a failed refund never pays twice.
+func (c *Client) issueRefund(ctx context.Context, event *Event, payment *Payment) *Event {
+	refund := &Refund{Amount: payment.Amount}
+
+	err := ErrRefundsDisabled
+	if c.Refunder != nil {
+		refund.Reference, err = c.Refunder.Refund(ctx, payment.PaymentIntent, payment.Amount, "refund-"+payment.PaymentIntent)
+	}
+	status := PaymentRefunded
+	if err != nil {
+		c.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not refund payment",
+				"event":   event.ID,
+				"user":    payment.Email,
+				"payment": payment.PaymentIntent,
+				"error":   err,
+			}},
+		)
+		status = PaymentRefundFailed
+		refund.Error = err.Error()
+	}
+	refund.Issued = err == nil
+
+	updated, err := c.modifyEvent(ctx, event.ID, func(oldEvent *StoredEvent, description *Description) error {
+		index := description.paymentIndex(payment.Reference)
+		if index < 0 || description.Payments[index].Status != PaymentRefundPending {
+			// the refund webhook got here first
+			return errNoChange
+		}
+		description.Payments[index].Status = status
+		description.Payments[index].Refund = refund.Reference
+		return nil
+	})
+	if err != nil {
+		c.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message": "could not record refund",
+				"event":   event.ID,
+				"user":    payment.Email,
+				"refund":  refund,
+				"error":   err,
+			}},
+		)
+		updated = event
+	}
+
+	updated.Refund = refund
+	return updated
+}
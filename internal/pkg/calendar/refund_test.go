@@ -0,0 +1,268 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/notify"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+)
+
+type refundCall struct {
+	paymentIntent  string
+	amount         int64
+	idempotencyKey string
+}
+
+// fakeRefunder records refunds and fails them while err is set.
+type fakeRefunder struct {
+	mu    sync.Mutex
+	err   error
+	calls []refundCall
+}
+
+func (f *fakeRefunder) Refund(ctx context.Context, paymentIntentID string, amount int64, idempotencyKey string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, refundCall{paymentIntentID, amount, idempotencyKey})
+	if f.err != nil {
+		return "", f.err
+	}
+	return fmt.Sprintf("re_%d", len(f.calls)), nil
+}
+
+var cardMember = &spreadsheet.User{Name: "Member", Email: "member@example.com"}
+
+// cardEvent starts after startsIn, with cardMember signed up and paid by
+// card.
+func cardEvent(startsIn time.Duration, policy *CancellationPolicy) StoredEvent {
+	event := testEvent(Description{
+		Price:           100,
+		MaxParticipants: 10,
+		Attendees:       []Attendee{{Name: cardMember.Name, Email: cardMember.Email, SignTime: time.Now()}},
+		Payments: Payments{{
+			Email:         cardMember.Email,
+			PaidTimestamp: time.Now(),
+			Method:        MethodStripe,
+			Status:        PaymentConfirmed,
+			Amount:        10000,
+			Reference:     "cs_member",
+			PaymentIntent: "pi_member",
+		}},
+		Policy: policy,
+	})
+	event.Start = time.Now().Add(startsIn)
+	event.End = event.Start.Add(2 * time.Hour)
+	return event
+}
+
+func newRefundClient(t *testing.T, store EventStore, refunder Refunder) *Client {
+	logger := testLogger(t)
+	return New(store, logger, notify.NewLogNotifier(logger), refunder)
+}
+
+func TestRemoveAttendeeRefundsBeforeDeadline(t *testing.T) {
+	refunder := &fakeRefunder{}
+	client := newRefundClient(t, newMemoryStore(cardEvent(7*24*time.Hour, nil)), refunder)
+
+	event, err := client.RemoveAttendee(context.Background(), "event", cardMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Refund == nil || !event.Refund.Issued || event.Refund.Amount != 10000 || event.Refund.Reference != "re_1" {
+		t.Errorf("got refund %+v", event.Refund)
+	}
+	if status := event.PaymentStatusOf(cardMember.Email); status != PaymentRefunded {
+		t.Errorf("got payment status %q, want %q", status, PaymentRefunded)
+	}
+	if attendeeIndex(event.Attendees, cardMember.Email) >= 0 {
+		t.Error("member is still attending")
+	}
+	want := []refundCall{{"pi_member", 10000, "refund-pi_member"}}
+	if fmt.Sprint(refunder.calls) != fmt.Sprint(want) {
+		t.Errorf("got refunds %v, want %v", refunder.calls, want)
+	}
+}
+
+func TestRemoveAttendeeKeepsPaymentAfterDeadline(t *testing.T) {
+	refunder := &fakeRefunder{}
+	// the default policy allows signing off but keeps payments during the
+	// last two days
+	client := newRefundClient(t, newMemoryStore(cardEvent(24*time.Hour, nil)), refunder)
+
+	event, err := client.RemoveAttendee(context.Background(), "event", cardMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendeeIndex(event.Attendees, cardMember.Email) >= 0 {
+		t.Error("member is still attending")
+	}
+	if event.Refund != nil || len(refunder.calls) != 0 {
+		t.Errorf("refunded %+v after the deadline", refunder.calls)
+	}
+	if status := event.PaymentStatusOf(cardMember.Email); status != PaymentConfirmed {
+		t.Errorf("got payment status %q, want %q", status, PaymentConfirmed)
+	}
+}
+
+func TestRemoveAttendeeSignOffDeadline(t *testing.T) {
+	policy := &CancellationPolicy{SignOffHours: 48, PaymentRemovalHours: 48, Late: LateBlock}
+	store := newMemoryStore(cardEvent(24*time.Hour, policy))
+	refunder := &fakeRefunder{}
+	client := newRefundClient(t, store, refunder)
+
+	_, err := client.RemoveAttendee(context.Background(), "event", cardMember)
+	var deadlineErr *DeadlineError
+	if !errors.As(err, &deadlineErr) || deadlineErr.Deadline != DeadlineSignOff {
+		t.Fatalf("got %v, want a sign-off DeadlineError", err)
+	}
+	event, err := client.GetEvent(context.Background(), "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendeeIndex(event.Attendees, cardMember.Email) < 0 || len(refunder.calls) != 0 {
+		t.Errorf("a blocked sign-off changed the event: %+v, refunds %v", event.Attendees, refunder.calls)
+	}
+}
+
+func TestUpdateEventPaymentRemovalDeadline(t *testing.T) {
+	refunder := &fakeRefunder{}
+	client := newRefundClient(t, newMemoryStore(cardEvent(24*time.Hour, nil)), refunder)
+
+	_, err := client.UpdateEvent(context.Background(), "event", cardMember)
+	var deadlineErr *DeadlineError
+	if !errors.As(err, &deadlineErr) || deadlineErr.Deadline != DeadlinePaymentRemoval {
+		t.Fatalf("got %v, want a payment removal DeadlineError", err)
+	}
+	if !errors.Is(err, ErrCannotRemovePayment) {
+		t.Error("the deadline error is not ErrCannotRemovePayment")
+	}
+	if len(refunder.calls) != 0 {
+		t.Errorf("refunded %v after the deadline", refunder.calls)
+	}
+}
+
+func TestRefundFailureIsRetried(t *testing.T) {
+	refunder := &fakeRefunder{err: errors.New("card declined")}
+	client := newRefundClient(t, newMemoryStore(cardEvent(7*24*time.Hour, nil)), refunder)
+	ctx := context.Background()
+
+	event, err := client.UpdateEvent(ctx, "event", cardMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Refund == nil || event.Refund.Issued || event.Refund.Error != "card declined" {
+		t.Errorf("got refund %+v", event.Refund)
+	}
+	if status := event.PaymentStatusOf(cardMember.Email); status != PaymentRefundFailed {
+		t.Fatalf("got payment status %q, want %q", status, PaymentRefundFailed)
+	}
+
+	refunder.err = nil
+	event, err = client.UpdateEvent(ctx, "event", cardMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Refund == nil || !event.Refund.Issued {
+		t.Errorf("got refund %+v", event.Refund)
+	}
+	if status := event.PaymentStatusOf(cardMember.Email); status != PaymentRefunded {
+		t.Errorf("got payment status %q, want %q", status, PaymentRefunded)
+	}
+	if len(refunder.calls) != 2 || refunder.calls[0].idempotencyKey != refunder.calls[1].idempotencyKey {
+		t.Errorf("the retry used another idempotency key: %v", refunder.calls)
+	}
+}
+
+func TestRefundsDisabled(t *testing.T) {
+	client := newRefundClient(t, newMemoryStore(cardEvent(7*24*time.Hour, nil)), nil)
+
+	event, err := client.UpdateEvent(context.Background(), "event", cardMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Refund == nil || event.Refund.Issued || event.Refund.Error != ErrRefundsDisabled.Error() {
+		t.Errorf("got refund %+v", event.Refund)
+	}
+	if status := event.PaymentStatusOf(cardMember.Email); status != PaymentRefundFailed {
+		t.Errorf("got payment status %q, want %q", status, PaymentRefundFailed)
+	}
+}
+
+func TestClaimRefund(t *testing.T) {
+	description := &Description{Payments: Payments{
+		{Email: "member@example.com", Method: MethodSwish, Status: PaymentClaimed},
+		{Email: "member@example.com", Method: MethodStripe, Status: PaymentConfirmed, PaymentIntent: "pi_member"},
+	}}
+
+	index := description.cardPaymentIndex("MEMBER@example.com")
+	if index != 1 {
+		t.Fatalf("got card payment %d, want 1", index)
+	}
+	claimed := description.claimRefund(index)
+	if claimed.Status != PaymentRefundPending || description.Payments[index].Status != PaymentRefundPending {
+		t.Errorf("got %+v, stored %+v", claimed, description.Payments[index])
+	}
+	// a claimed payment cannot be refunded a second time
+	if index := description.cardPaymentIndex("member@example.com"); index >= 0 {
+		t.Errorf("got card payment %d after the claim", index)
+	}
+}
+
+func TestCancellationPolicy(t *testing.T) {
+	start := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+	attendee := Attendee{Name: "Member", Email: "member@example.com"}
+
+	tests := []struct {
+		name       string
+		policy     CancellationPolicy
+		before     time.Duration
+		wantRecord LateCancellation
+		signOff    bool
+		removal    bool
+	}{
+		{"before both deadlines", CancellationPolicy{SignOffHours: 24, PaymentRemovalHours: 48, Late: LateBlock}, 72 * time.Hour, "", true, true},
+		{"between the deadlines", CancellationPolicy{SignOffHours: 24, PaymentRemovalHours: 48, Late: LateBlock}, 36 * time.Hour, "", true, false},
+		{"after both deadlines", CancellationPolicy{SignOffHours: 24, PaymentRemovalHours: 48, Late: LateBlock}, time.Hour, "", false, false},
+		{"at the deadline", CancellationPolicy{SignOffHours: 24, PaymentRemovalHours: 24, Late: LateBlock}, 24 * time.Hour, "", false, false},
+		{"late fee", CancellationPolicy{SignOffHours: 24, Late: LateFee, LateFee: 50}, time.Hour, LateFee, true, true},
+		{"late strike", CancellationPolicy{SignOffHours: 24, Late: LateStrike}, time.Hour, LateStrike, true, true},
+		{"late fee in time", CancellationPolicy{SignOffHours: 24, Late: LateFee, LateFee: 50}, 48 * time.Hour, "", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := start.Add(-test.before)
+
+			record, err := test.policy.checkSignOff(start, now, attendee)
+			if test.signOff != (err == nil) {
+				t.Errorf("sign-off: got %v", err)
+			}
+			if err != nil {
+				var deadlineErr *DeadlineError
+				if !errors.As(err, &deadlineErr) || deadlineErr.Deadline != DeadlineSignOff ||
+					!deadlineErr.At.Equal(start.Add(-time.Duration(test.policy.SignOffHours)*time.Hour)) {
+					t.Errorf("got %v, want the sign-off deadline", err)
+				}
+			}
+			switch {
+			case test.wantRecord == "" && record != nil:
+				t.Errorf("got late cancellation %+v", record)
+			case test.wantRecord != "" && (record == nil || record.Action != test.wantRecord || record.Fee != test.policy.LateFee || record.Email != attendee.Email):
+				t.Errorf("got late cancellation %+v, want %s", record, test.wantRecord)
+			}
+
+			err = test.policy.checkPaymentRemoval(start, now)
+			if test.removal != (err == nil) {
+				t.Errorf("payment removal: got %v", err)
+			}
+			if err != nil && !errors.Is(err, ErrCannotRemovePayment) {
+				t.Errorf("got %v, want ErrCannotRemovePayment", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+)
+
+const (
+	DefaultTimezone = "Europe/Stockholm"
+
+	// maxOccurrences bounds how many events a single series can generate.
+	maxOccurrences = 366
+)
+
+var ErrSeriesNotFound = errors.New("series not found")
+
+// Series is a recurring slot, like the Tuesday evening session, that
+// generates one concrete event per occurrence for a season.
+type Series struct {
+	ID    string `json:"id" yaml:"-"`
+	Title string `json:"title" yaml:"title"`
+	// RRule supports the FREQ (DAILY or WEEKLY), INTERVAL, BYDAY, COUNT
+	// and UNTIL parts of an iCalendar recurrence rule.
+	RRule string `json:"rrule" yaml:"rrule"`
+	// Start is the first occurrence; its time of day is used for all of
+	// them.
+	Start           time.Time       `json:"start" yaml:"start"`
+	DurationMinutes int             `json:"duration_minutes" yaml:"duration_minutes"`
+	SeasonEnd       time.Time       `json:"season_end" yaml:"season_end"`
+	Timezone        string          `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Template        SessionTemplate `json:"template" yaml:"template"`
+	// Exceptions are dates (2006-01-02) without a session.
+	Exceptions []string `json:"exceptions" yaml:"exceptions"`
+}
+
+// SessionTemplate holds the fields copied to every event of a series.
+type SessionTemplate struct {
+	Location        string              `json:"location" yaml:"location"`
+	Price           int                 `json:"price" yaml:"price"`
+	Level           string              `json:"level" yaml:"level"`
+	MaxParticipants int                 `json:"max_participants" yaml:"max_participants"`
+	Policy          *CancellationPolicy `json:"policy,omitempty" yaml:"policy,omitempty"`
+}
+
+// SeriesSync reports what happened to the events of a series.
+type SeriesSync struct {
+	Series  *Series  `json:"series"`
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Deleted []string `json:"deleted"`
+	// Kept are events left untouched because people signed up for them.
+	Kept []string `json:"kept"`
+	// Mismatched are kept events whose time no longer matches their
+	// occurrence, for organizers to move or cancel by hand.
+	Mismatched []string `json:"mismatched"`
+}
+
+type recurrence struct {
+	freq     string
+	interval int
+	byDay    []time.Weekday
+	count    int
+	until    time.Time
+}
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+func parseRRule(rule string) (*recurrence, error) {
+	r := &recurrence{interval: 1}
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	for _, part := range strings.Split(rule, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("malformed part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err == nil && r.interval < 1 {
+				err = errors.New("must be positive")
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+		case "UNTIL":
+			r.until, err = time.Parse("20060102T150405Z", value)
+			if err != nil {
+				r.until, err = time.Parse("20060102", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("unknown day %q", day)
+				}
+				r.byDay = append(r.byDay, weekday)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported part %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if r.freq != "DAILY" && r.freq != "WEEKLY" {
+		return nil, fmt.Errorf("unsupported FREQ %q", r.freq)
+	}
+	return r, nil
+}
+
+func (s *Series) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.LoadLocation(DefaultTimezone)
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+func (s *Series) Validate() error {
+	if s.Title == "" {
+		return &ValidationError{Field: "title", Reason: "is required"}
+	}
+	if _, err := parseRRule(s.RRule); err != nil {
+		return &ValidationError{Field: "rrule", Reason: err.Error()}
+	}
+	if s.Start.IsZero() {
+		return &ValidationError{Field: "start", Reason: "is required"}
+	}
+	if s.DurationMinutes < 1 {
+		return &ValidationError{Field: "duration_minutes", Reason: "must be at least 1"}
+	}
+	if s.SeasonEnd.Before(s.Start) {
+		return &ValidationError{Field: "season_end", Reason: "must be after start"}
+	}
+	if _, err := s.location(); err != nil {
+		return &ValidationError{Field: "timezone", Reason: err.Error()}
+	}
+	for _, exception := range s.Exceptions {
+		if _, err := time.Parse(model.DateLayout, exception); err != nil {
+			return &ValidationError{Field: "exceptions", Reason: fmt.Sprintf("%q is not a date", exception)}
+		}
+	}
+
+	session := s.session(s.Start)
+	return session.Validate()
+}
+
+// Occurrences returns the start time of every event of the season, in the
+// series time zone so that daylight saving time keeps the local time.
+func (s *Series) Occurrences() ([]time.Time, error) {
+	rule, err := parseRRule(s.RRule)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+
+	start := s.Start.In(loc)
+	until := s.SeasonEnd
+	if !rule.until.IsZero() && rule.until.Before(until) {
+		until = rule.until
+	}
+	// the last day of the season is included
+	until = time.Date(until.In(loc).Year(), until.In(loc).Month(), until.In(loc).Day()+1, 0, 0, 0, 0, loc)
+
+	excluded := map[string]bool{}
+	for _, exception := range s.Exceptions {
+		excluded[exception] = true
+	}
+
+	byDay := rule.byDay
+	if len(byDay) == 0 || rule.freq == "DAILY" {
+		byDay = []time.Weekday{start.Weekday()}
+	}
+	sort.Slice(byDay, func(i, j int) bool {
+		return (int(byDay[i])+6)%7 < (int(byDay[j])+6)%7
+	})
+
+	occurrences := []time.Time{}
+	generated := 0
+	for period := 0; generated < maxOccurrences; period++ {
+		candidates := []time.Time{}
+		if rule.freq == "DAILY" {
+			candidates = append(candidates, s.at(start, period*rule.interval, loc))
+		} else {
+			// weeks start on monday, like in the BYDAY default of RFC 5545
+			weekStart := -((int(start.Weekday()) + 6) % 7)
+			for _, day := range byDay {
+				offset := weekStart + (int(day)+6)%7 + period*rule.interval*7
+				candidates = append(candidates, s.at(start, offset, loc))
+			}
+		}
+
+		for _, candidate := range candidates {
+			if candidate.Before(start) || generated >= maxOccurrences {
+				continue
+			}
+			if !candidate.Before(until) || (rule.count > 0 && generated >= rule.count) {
+				return occurrences, nil
+			}
+			generated++
+			if !excluded[candidate.Format(model.DateLayout)] {
+				occurrences = append(occurrences, candidate)
+			}
+		}
+	}
+	return occurrences, nil
+}
+
+func (s *Series) at(start time.Time, days int, loc *time.Location) time.Time {
+	return time.Date(start.Year(), start.Month(), start.Day()+days,
+		start.Hour(), start.Minute(), 0, 0, loc)
+}
+
+func (s *Series) session(start time.Time) Session {
+	return Session{
+		Title:           s.Title,
+		Start:           start,
+		End:             start.Add(time.Duration(s.DurationMinutes) * time.Minute),
+		Location:        s.Template.Location,
+		Price:           s.Template.Price,
+		Level:           s.Template.Level,
+		MaxParticipants: s.Template.MaxParticipants,
+		Policy:          s.Template.Policy,
+	}
+}
+
+func (c *Client) ListSeries(ctx context.Context) ([]*Series, error) {
+	return c.Store.ListSeries(ctx)
+}
+
+func (c *Client) GetSeries(ctx context.Context, seriesID string) (*Series, error) {
+	return c.Store.GetSeries(ctx, seriesID)
+}
+
+// SaveSeries creates or updates a series and brings its future events in
+// line with it.
+func (c *Client) SaveSeries(ctx context.Context, series Series) (*SeriesSync, error) {
+	if err := series.Validate(); err != nil {
+		return nil, err
+	}
+
+	if series.ID != "" {
+		if _, err := c.Store.GetSeries(ctx, series.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	saved, err := c.Store.SaveSeries(ctx, &series)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences, err := saved.Occurrences()
+	if err != nil {
+		return nil, err
+	}
+	return c.syncSeries(ctx, saved, occurrences)
+}
+
+// DeleteSeries removes the series and its future events nobody signed up
+// for.
+func (c *Client) DeleteSeries(ctx context.Context, seriesID string) (*SeriesSync, error) {
+	series, err := c.Store.GetSeries(ctx, seriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.syncSeries(ctx, series, []time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return result, c.Store.DeleteSeries(ctx, seriesID)
+}
+
+// syncSeries makes the future events of series match occurrences: events
+// without sign-ups are updated from the template or deleted when their
+// occurrence is gone, and missing occurrences are created. Events are
+// matched to occurrences by their date, so changing the time of the series
+// moves its events. Events with attendees are left untouched.
+func (c *Client) syncSeries(ctx context.Context, series *Series, occurrences []time.Time) (*SeriesSync, error) {
+	now := time.Now()
+	result := &SeriesSync{
+		Series:     series,
+		Created:    []string{},
+		Updated:    []string{},
+		Deleted:    []string{},
+		Kept:       []string{},
+		Mismatched: []string{},
+	}
+	loc, err := series.location()
+	if err != nil {
+		return nil, err
+	}
+	date := func(t time.Time) string {
+		return t.In(loc).Format(model.DateLayout)
+	}
+
+	pending := map[string]time.Time{}
+	for _, occurrence := range occurrences {
+		if occurrence.After(now) {
+			pending[date(occurrence)] = occurrence
+		}
+	}
+
+	existing, err := c.seriesEvents(ctx, series.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range existing {
+		occurrence, planned := pending[date(event.Start)]
+		delete(pending, date(event.Start))
+
+		if planned {
+			session := series.session(occurrence)
+			kept := false
+			_, err := c.modifyEvent(ctx, event.ID, func(oldEvent *StoredEvent, description *Description) error {
+				if description.hasBookings() {
+					kept = true
+					return errNoChange
+				}
+				session.apply(oldEvent, description)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case !kept:
+				result.Updated = append(result.Updated, event.ID)
+			case !event.Start.Equal(occurrence):
+				result.Kept = append(result.Kept, event.ID)
+				result.Mismatched = append(result.Mismatched, event.ID)
+			default:
+				result.Kept = append(result.Kept, event.ID)
+			}
+			continue
+		}
+
+		err := c.DeleteEvent(ctx, event.ID, false)
+		switch {
+		case errors.Is(err, ErrEventHasAttendees):
+			result.Kept = append(result.Kept, event.ID)
+		case err != nil:
+			return nil, err
+		default:
+			result.Deleted = append(result.Deleted, event.ID)
+		}
+	}
+
+	for _, occurrence := range occurrences {
+		if _, missing := pending[date(occurrence)]; !missing {
+			continue
+		}
+
+		event := &StoredEvent{}
+		description := &Description{
+			Attendees: []Attendee{},
+			Payments:  Payments{},
+			Series:    series.ID,
+		}
+		session := series.session(occurrence)
+		session.apply(event, description)
+		event.Description = description.String()
+
+		newEvent, err := c.Store.CreateEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		result.Created = append(result.Created, newEvent.ID)
+	}
+
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message":    "synchronized series",
+			"series":     series.ID,
+			"created":    len(result.Created),
+			"updated":    len(result.Updated),
+			"deleted":    len(result.Deleted),
+			"kept":       len(result.Kept),
+			"mismatched": result.Mismatched,
+		}},
+	)
+	return result, nil
+}
+
+// seriesEvents returns the events of a series starting after from.
+func (c *Client) seriesEvents(ctx context.Context, seriesID string, from time.Time) ([]*StoredEvent, error) {
+	events := []*StoredEvent{}
+	query := EventQuery{From: from, Limit: 250}
+	for {
+		page, nextPageToken, err := c.Store.ListEvents(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range page {
+			description, err := readDescription(event)
+			if err != nil || description.Series != seriesID {
+				continue
+			}
+			events = append(events, event)
+		}
+
+		if nextPageToken == "" {
+			return events, nil
+		}
+		query.PageToken = nextPageToken
+	}
+}
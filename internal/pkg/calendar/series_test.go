@@ -0,0 +1,289 @@
+package calendar
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    recurrence
+		wantErr bool
+	}{
+		{rule: "FREQ=WEEKLY", want: recurrence{freq: "WEEKLY", interval: 1}},
+		{rule: "RRULE:freq=daily;interval=2", want: recurrence{freq: "DAILY", interval: 2}},
+		{rule: "FREQ=WEEKLY;BYDAY=TU,th", want: recurrence{freq: "WEEKLY", interval: 1, byDay: []time.Weekday{time.Tuesday, time.Thursday}}},
+		{rule: "FREQ=WEEKLY;COUNT=10", want: recurrence{freq: "WEEKLY", interval: 1, count: 10}},
+		{rule: "FREQ=WEEKLY;UNTIL=20240601", want: recurrence{freq: "WEEKLY", interval: 1, until: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}},
+		{rule: "FREQ=WEEKLY;UNTIL=20240601T180000Z", want: recurrence{freq: "WEEKLY", interval: 1, until: time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)}},
+		{rule: "FREQ=MONTHLY", wantErr: true},
+		{rule: "INTERVAL=2", wantErr: true},
+		{rule: "FREQ=WEEKLY;INTERVAL=0", wantErr: true},
+		{rule: "FREQ=WEEKLY;COUNT=many", wantErr: true},
+		{rule: "FREQ=WEEKLY;UNTIL=june", wantErr: true},
+		{rule: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{rule: "FREQ=WEEKLY;BYMONTH=1", wantErr: true},
+		{rule: "FREQ=WEEKLY;COUNT", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			got, err := parseRRule(test.rule)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 January 2024 is a Tuesday
+	tuesday := time.Date(2024, 1, 2, 18, 0, 0, 0, loc)
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2024, month, day, 0, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name       string
+		rule       string
+		start      time.Time
+		seasonEnd  time.Time
+		exceptions []string
+		want       []string
+	}{
+		{
+			name:      "weekly",
+			rule:      "FREQ=WEEKLY",
+			start:     tuesday,
+			seasonEnd: day(1, 23),
+			want:      []string{"2024-01-02 18:00", "2024-01-09 18:00", "2024-01-16 18:00", "2024-01-23 18:00"},
+		},
+		{
+			name:      "interval",
+			rule:      "FREQ=WEEKLY;INTERVAL=2",
+			start:     tuesday,
+			seasonEnd: day(1, 31),
+			want:      []string{"2024-01-02 18:00", "2024-01-16 18:00", "2024-01-30 18:00"},
+		},
+		{
+			name:      "count",
+			rule:      "FREQ=WEEKLY;COUNT=3",
+			start:     tuesday,
+			seasonEnd: day(6, 1),
+			want:      []string{"2024-01-02 18:00", "2024-01-09 18:00", "2024-01-16 18:00"},
+		},
+		{
+			name:       "count includes exceptions",
+			rule:       "FREQ=WEEKLY;COUNT=3",
+			start:      tuesday,
+			seasonEnd:  day(6, 1),
+			exceptions: []string{"2024-01-09"},
+			want:       []string{"2024-01-02 18:00", "2024-01-16 18:00"},
+		},
+		{
+			name:      "until before the season end",
+			rule:      "FREQ=WEEKLY;UNTIL=20240116",
+			start:     tuesday,
+			seasonEnd: day(6, 1),
+			want:      []string{"2024-01-02 18:00", "2024-01-09 18:00", "2024-01-16 18:00"},
+		},
+		{
+			name:      "season end before until",
+			rule:      "FREQ=WEEKLY;UNTIL=20240601",
+			start:     tuesday,
+			seasonEnd: day(1, 9),
+			want:      []string{"2024-01-02 18:00", "2024-01-09 18:00"},
+		},
+		{
+			name:      "by day",
+			rule:      "FREQ=WEEKLY;BYDAY=TH,TU",
+			start:     tuesday,
+			seasonEnd: day(1, 11),
+			want:      []string{"2024-01-02 18:00", "2024-01-04 18:00", "2024-01-09 18:00", "2024-01-11 18:00"},
+		},
+		{
+			name:      "by day before the start",
+			rule:      "FREQ=WEEKLY;BYDAY=MO,WE",
+			start:     time.Date(2024, 1, 3, 18, 0, 0, 0, loc),
+			seasonEnd: day(1, 10),
+			want:      []string{"2024-01-03 18:00", "2024-01-08 18:00", "2024-01-10 18:00"},
+		},
+		{
+			name:      "daily",
+			rule:      "FREQ=DAILY;INTERVAL=3",
+			start:     tuesday,
+			seasonEnd: day(1, 10),
+			want:      []string{"2024-01-02 18:00", "2024-01-05 18:00", "2024-01-08 18:00"},
+		},
+		{
+			name:      "daylight saving time",
+			rule:      "FREQ=WEEKLY",
+			start:     time.Date(2024, 3, 19, 18, 0, 0, 0, loc),
+			seasonEnd: day(4, 2),
+			want:      []string{"2024-03-19 18:00", "2024-03-26 18:00", "2024-04-02 18:00"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			series := Series{RRule: test.rule, Start: test.start, SeasonEnd: test.seasonEnd, Exceptions: test.exceptions}
+			occurrences, err := series.Occurrences()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, occurrence := range occurrences {
+				got = append(got, occurrence.In(loc).Format("2006-01-02 15:04"))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOccurrencesDaylightSavingTime(t *testing.T) {
+	series := Series{
+		RRule:     "FREQ=WEEKLY",
+		Start:     time.Date(2024, 3, 26, 17, 0, 0, 0, time.UTC),
+		SeasonEnd: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
+	}
+	occurrences, err := series.Occurrences()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// summer time started on 31 March, the session stays at 18:00
+	if len(occurrences) != 2 || occurrences[1].UTC().Hour() != 16 {
+		t.Errorf("got %v", occurrences)
+	}
+}
+
+func TestOccurrencesCapped(t *testing.T) {
+	series := Series{
+		RRule:     "FREQ=DAILY",
+		Start:     time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+		SeasonEnd: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	occurrences, err := series.Occurrences()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(occurrences) != maxOccurrences {
+		t.Errorf("got %d occurrences, want %d", len(occurrences), maxOccurrences)
+	}
+}
+
+func seriesEvent(id string, start time.Time, attendees ...Attendee) StoredEvent {
+	description := Description{Version: CurrentDescriptionVersion, Series: "series", Attendees: attendees}
+	return StoredEvent{
+		ID:          id,
+		Summary:     "Tuesday",
+		Start:       start,
+		End:         start.Add(time.Hour),
+		Description: description.String(),
+	}
+}
+
+func TestSyncSeries(t *testing.T) {
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().In(loc)
+	week := func(n int, hour int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+7*(n+1), hour, 0, 0, 0, loc)
+	}
+	member := Attendee{Name: "Member", Email: "member@example.com", SignTime: now}
+
+	// the series moved from 18:00 to 19:00
+	series := &Series{
+		ID:              "series",
+		Title:           "Tuesday",
+		RRule:           "FREQ=WEEKLY",
+		Start:           week(0, 19),
+		DurationMinutes: 60,
+		SeasonEnd:       week(3, 0),
+		Timezone:        DefaultTimezone,
+		Template:        SessionTemplate{MaxParticipants: 10},
+	}
+	other := testEvent(Description{})
+	other.ID = "other"
+	other.Start = week(2, 18)
+	store := newMemoryStore(
+		seriesEvent("unbooked", week(0, 18)),
+		seriesEvent("booked", week(1, 18), member),
+		seriesEvent("booked on time", week(3, 19), member),
+		seriesEvent("gone", week(4, 19)),
+		seriesEvent("gone booked", week(5, 19), member),
+		other,
+	)
+	client := newTestClient(t, store)
+
+	occurrences, err := series.Occurrences()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.syncSeries(context.Background(), series, occurrences)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(result.Kept)
+	want := &SeriesSync{
+		Series:     series,
+		Created:    []string{"created1"},
+		Updated:    []string{"unbooked"},
+		Deleted:    []string{"gone"},
+		Kept:       []string{"booked", "booked on time", "gone booked"},
+		Mismatched: []string{"booked"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+
+	starts := map[string]time.Time{
+		"unbooked":       week(0, 19),
+		"booked":         week(1, 18),
+		"created1":       week(2, 19),
+		"booked on time": week(3, 19),
+		"gone booked":    week(5, 19),
+		"other":          week(2, 18),
+	}
+	if len(store.events) != len(starts) {
+		t.Errorf("got %d events, want %d", len(store.events), len(starts))
+	}
+	for id, start := range starts {
+		event, found := store.events[id]
+		if !found {
+			t.Errorf("%s is missing", id)
+			continue
+		}
+		if !event.Start.Equal(start) {
+			t.Errorf("%s starts at %v, want %v", id, event.Start, start)
+		}
+	}
+
+	// nothing is created when the series is saved again
+	result, err = client.syncSeries(context.Background(), series, occurrences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 0 || len(result.Deleted) != 0 || len(result.Updated) != 2 {
+		t.Errorf("second sync: got %+v", result)
+	}
+}
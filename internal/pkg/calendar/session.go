@@ -0,0 +1,141 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+)
+
+var ErrEventHasAttendees = errors.New("event has attendees or payments")
+
+// Session holds the fields organizers set on an event. The server turns it
+// into the event description, so nobody has to write YAML by hand.
+type Session struct {
+	Title           string    `json:"title"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Location        string    `json:"location"`
+	Price           int       `json:"price"`
+	Level           string    `json:"level"`
+	MaxParticipants int       `json:"max_participants"`
+	// Policy is optional, events without one use DefaultPolicy.
+	Policy *CancellationPolicy `json:"policy,omitempty"`
+}
+
+// ValidationError tells which session field is wrong.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func (s *Session) Validate() error {
+	if s.Title == "" {
+		return &ValidationError{Field: "title", Reason: "is required"}
+	}
+	if s.Start.IsZero() {
+		return &ValidationError{Field: "start", Reason: "is required"}
+	}
+	if !s.End.After(s.Start) {
+		return &ValidationError{Field: "end", Reason: "must be after start"}
+	}
+	if s.Price < 0 {
+		return &ValidationError{Field: "price", Reason: "cannot be negative"}
+	}
+	if _, err := model.ParseLevel(s.Level); s.Level != "" && err != nil {
+		return &ValidationError{Field: "level", Reason: err.Error()}
+	}
+	if s.MaxParticipants < 1 {
+		return &ValidationError{Field: "max_participants", Reason: "must be at least 1"}
+	}
+	if s.Policy != nil {
+		return s.Policy.Validate()
+	}
+	return nil
+}
+
+// apply copies the session fields to the stored event and its description,
+// leaving attendees, waitlist and payments untouched.
+func (s *Session) apply(event *StoredEvent, description *Description) {
+	level, _ := model.ParseLevel(s.Level)
+
+	event.Summary = s.Title
+	event.Location = s.Location
+	event.Start = s.Start
+	event.End = s.End
+	description.Price = s.Price
+	description.Level = level.String()
+	description.MaxParticipants = s.MaxParticipants
+	description.Policy = s.Policy
+}
+
+func (c *Client) CreateEvent(ctx context.Context, session Session) (*Event, error) {
+	if err := session.Validate(); err != nil {
+		return nil, err
+	}
+
+	event := &StoredEvent{}
+	description := &Description{
+		Attendees: []Attendee{},
+		Payments:  Payments{},
+	}
+	session.apply(event, description)
+	event.Description = description.String()
+
+	newEvent, err := c.Store.CreateEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message": "created event",
+			"event":   newEvent.ID,
+		}},
+	)
+	return c.ToEvent(newEvent)
+}
+
+func (c *Client) EditEvent(ctx context.Context, eventID string, session Session) (*Event, error) {
+	if err := session.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.modifyEvent(ctx, eventID, func(oldEvent *StoredEvent, description *Description) error {
+		session.apply(oldEvent, description)
+		description.promoteWaitlist()
+		return nil
+	})
+}
+
+// DeleteEvent removes an event. Events somebody signed up or paid for are
+// only removed when force is set.
+func (c *Client) DeleteEvent(ctx context.Context, eventID string, force bool) error {
+	event, description, err := c.getStoredEvent(ctx, eventID)
+	if err != nil {
+		return err
+	}
+
+	if description.hasBookings() && !force {
+		return ErrEventHasAttendees
+	}
+
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message":  "deleting event",
+			"event":    event.ID,
+			"attendes": description.Attendees,
+			"payments": description.Payments,
+		}},
+	)
+	return c.Store.DeleteEvent(ctx, event.ID)
+}
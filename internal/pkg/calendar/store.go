@@ -0,0 +1,63 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrConflict is returned by EventStore.UpdateEvent when the stored
+	// event changed since it was read.
+	ErrConflict = errors.New("event changed since it was read")
+
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// StoredEvent is a session as persisted by an EventStore. The booking
+// state (attendees, waitlist and payments) lives in Description, which is
+// the YAML document read by readDescription.
+type StoredEvent struct {
+	ID          string    `json:"id"`
+	ETag        string    `json:"etag"`
+	Summary     string    `json:"summary"`
+	Location    string    `json:"location"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Description string    `json:"description"`
+}
+
+// EventQuery selects events starting in [From, To). A zero To means no
+// upper bound. PageToken is the opaque cursor returned with the previous
+// page.
+type EventQuery struct {
+	From      time.Time
+	To        time.Time
+	Limit     int
+	PageToken string
+}
+
+// EventStore persists sessions together with their attendees and payments.
+type EventStore interface {
+	// ListEvents returns one page of events matching query, ordered by
+	// start time, and the token of the next page when there is one.
+	ListEvents(ctx context.Context, query EventQuery) ([]*StoredEvent, string, error)
+	GetEvent(ctx context.Context, id string) (*StoredEvent, error)
+	CreateEvent(ctx context.Context, event *StoredEvent) (*StoredEvent, error)
+	// UpdateEvent writes event only if the stored copy still has
+	// event.ETag, otherwise it returns ErrConflict.
+	UpdateEvent(ctx context.Context, event *StoredEvent) (*StoredEvent, error)
+	DeleteEvent(ctx context.Context, id string) error
+
+	SeriesStore
+}
+
+// SeriesStore persists series definitions. The events a series generates
+// are ordinary events that reference it from their description.
+type SeriesStore interface {
+	ListSeries(ctx context.Context) ([]*Series, error)
+	GetSeries(ctx context.Context, id string) (*Series, error)
+	// SaveSeries creates the series when it has no ID yet.
+	SaveSeries(ctx context.Context, series *Series) (*Series, error)
+	DeleteSeries(ctx context.Context, id string) error
+}
@@ -0,0 +1,226 @@
+// Package facebook checks Facebook user access tokens with the Graph API.
+package facebook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultGraphURL   = "https://graph.facebook.com"
+	DefaultAPIVersion = "v14.0"
+)
+
+var (
+	ErrDisabled     = errors.New("facebook login is not enabled")
+	ErrInvalidToken = errors.New("invalid facebook token")
+	ErrOtherApp     = errors.New("facebook token was issued to another app")
+	ErrExpiredToken = errors.New("facebook token expired")
+	ErrMissingScope = errors.New("facebook token lacks a required permission")
+	ErrNoEmail      = errors.New("facebook account has no email")
+)
+
+// Config holds the Facebook app that members log in to.
+type Config struct {
+	AppID     string
+	AppSecret string
+	// GraphURL and APIVersion default to the real Graph API.
+	GraphURL   string
+	APIVersion string
+	// Scopes lists the permissions the token must carry, email when empty.
+	Scopes []string
+}
+
+// User is the Facebook account behind a token.
+type User struct {
+	ID      string
+	Name    string
+	Email   string
+	Picture string
+}
+
+type API interface {
+	Validate(ctx context.Context, token string) (*User, error)
+}
+
+type Client struct {
+	AppID      string
+	AppSecret  string
+	GraphURL   string
+	APIVersion string
+	Scopes     []string
+	HTTPClient *http.Client
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.AppID == "" || cfg.AppSecret == "" {
+		return nil, errors.New("the facebook app id and secret are required")
+	}
+	if cfg.GraphURL == "" {
+		cfg.GraphURL = DefaultGraphURL
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = DefaultAPIVersion
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email"}
+	}
+	return &Client{
+		AppID:      cfg.AppID,
+		AppSecret:  cfg.AppSecret,
+		GraphURL:   strings.TrimSuffix(cfg.GraphURL, "/"),
+		APIVersion: cfg.APIVersion,
+		Scopes:     cfg.Scopes,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// DebugToken is the data debug_token reports about a token.
+type DebugToken struct {
+	AppID     string   `json:"app_id"`
+	Type      string   `json:"type"`
+	IsValid   bool     `json:"is_valid"`
+	ExpiresAt int64    `json:"expires_at"`
+	Scopes    []string `json:"scopes"`
+	UserID    string   `json:"user_id"`
+	Error     *Error   `json:"error,omitempty"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+	Type    string `json:"type,omitempty"`
+	Code    int    `json:"code"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("graph api error %d: %s", e.Code, e.Message)
+}
+
+type Me struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Picture struct {
+		Data struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	} `json:"picture"`
+}
+
+// Validate makes sure the token was issued to our app, for a user, and is
+// still valid, before reading the account it belongs to.
+func (c *Client) Validate(ctx context.Context, token string) (*User, error) {
+	debug, err := c.debugToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.check(debug, time.Now()); err != nil {
+		return nil, err
+	}
+
+	me := Me{}
+	err = c.get(ctx, "me", url.Values{
+		"fields":          {"id,name,email,picture"},
+		"access_token":    {token},
+		"appsecret_proof": {AppSecretProof(c.AppSecret, token)},
+	}, &me)
+	if err != nil {
+		return nil, err
+	}
+	if me.ID != debug.UserID {
+		return nil, ErrInvalidToken
+	}
+	if me.Email == "" {
+		return nil, ErrNoEmail
+	}
+
+	return &User{
+		ID:      me.ID,
+		Name:    me.Name,
+		Email:   me.Email,
+		Picture: me.Picture.Data.URL,
+	}, nil
+}
+
+func (c *Client) debugToken(ctx context.Context, token string) (*DebugToken, error) {
+	answer := struct {
+		Data DebugToken `json:"data"`
+	}{}
+	err := c.get(ctx, "debug_token", url.Values{
+		"input_token":  {token},
+		"access_token": {c.AppID + "|" + c.AppSecret},
+	}, &answer)
+	if err != nil {
+		return nil, err
+	}
+	return &answer.Data, nil
+}
+
+func (c *Client) check(debug *DebugToken, now time.Time) error {
+	if !debug.IsValid || debug.Type != "USER" || debug.UserID == "" {
+		if debug.Error != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidToken, debug.Error.Message)
+		}
+		return ErrInvalidToken
+	}
+	if debug.AppID != c.AppID {
+		return ErrOtherApp
+	}
+	// zero means the token does not expire
+	if debug.ExpiresAt != 0 && now.Unix() >= debug.ExpiresAt {
+		return ErrExpiredToken
+	}
+
+	granted := map[string]bool{}
+	for _, scope := range debug.Scopes {
+		granted[scope] = true
+	}
+	for _, scope := range c.Scopes {
+		if !granted[scope] {
+			return fmt.Errorf("%w: %s", ErrMissingScope, scope)
+		}
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, answer interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.GraphURL+"/"+c.APIVersion+"/"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		failure := struct {
+			Error *Error `json:"error"`
+		}{}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, failure.Error)
+		}
+		return fmt.Errorf("graph api answered %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(answer)
+}
+
+// AppSecretProof signs a user token with the app secret, so a stolen token
+// cannot be used by someone without the secret.
+func AppSecretProof(appSecret string, token string) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,109 @@
+package facebook_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook"
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook/fakegraph"
+)
+
+const (
+	appID     = "1234"
+	appSecret = "app-secret"
+)
+
+func newTestClient(t *testing.T) (*facebook.Client, *fakegraph.Server) {
+	t.Helper()
+	graph := fakegraph.New(appID, appSecret)
+	server := httptest.NewServer(graph)
+	t.Cleanup(server.Close)
+
+	client, err := facebook.New(facebook.Config{
+		AppID:     appID,
+		AppSecret: appSecret,
+		GraphURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, graph
+}
+
+func validToken() fakegraph.Token {
+	return fakegraph.Token{
+		UserID:    "42",
+		Name:      "Member",
+		Email:     "member@example.com",
+		Scopes:    []string{"public_profile", "email"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestValidate(t *testing.T) {
+	client, graph := newTestClient(t)
+	graph.Add("token", validToken())
+
+	user, err := client.Validate(context.Background(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "42" || user.Email != "member@example.com" || user.Name != "Member" {
+		t.Errorf("got %+v", user)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	otherApp := validToken()
+	otherApp.AppID = "5678"
+
+	revoked := validToken()
+	revoked.Revoked = true
+
+	expired := validToken()
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+	noEmailScope := validToken()
+	noEmailScope.Scopes = []string{"public_profile"}
+
+	otherAccount := validToken()
+	otherAccount.MeID = "43"
+
+	noEmail := validToken()
+	noEmail.Email = ""
+
+	tests := []struct {
+		name  string
+		token fakegraph.Token
+		want  error
+	}{
+		{"other app", otherApp, facebook.ErrOtherApp},
+		{"not valid", revoked, facebook.ErrInvalidToken},
+		{"expired", expired, facebook.ErrExpiredToken},
+		{"missing email scope", noEmailScope, facebook.ErrMissingScope},
+		{"me of another account", otherAccount, facebook.ErrInvalidToken},
+		{"no email", noEmail, facebook.ErrNoEmail},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, graph := newTestClient(t)
+			graph.Add("token", test.token)
+
+			user, err := client.Validate(context.Background(), "token")
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %+v, %v, want %v", user, err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateUnknownToken(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	if _, err := client.Validate(context.Background(), "unknown"); !errors.Is(err, facebook.ErrInvalidToken) {
+		t.Fatalf("got %v, want ErrInvalidToken", err)
+	}
+}
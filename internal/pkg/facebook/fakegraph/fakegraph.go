@@ -0,0 +1,130 @@
+// Package fakegraph is a stand-in for the parts of the Facebook Graph API
+// used for login, so it can be tried without a Facebook app.
+package fakegraph
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/facebook"
+)
+
+// Token describes a user access token the server knows.
+type Token struct {
+	AppID     string   `json:"app_id"`
+	UserID    string   `json:"user_id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expires_at"`
+	// Revoked tokens are still known, but debug_token reports them invalid.
+	Revoked bool `json:"revoked,omitempty"`
+	// MeID is answered by me instead of UserID, like a token swapped for
+	// one of another account would.
+	MeID string `json:"me_id,omitempty"`
+}
+
+// Server answers debug_token and me requests for the tokens added to it.
+type Server struct {
+	AppID     string
+	AppSecret string
+
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+func New(appID string, appSecret string) *Server {
+	return &Server{
+		AppID:     appID,
+		AppSecret: appSecret,
+		tokens:    map[string]Token{},
+	}
+}
+
+// Add makes token known. Tokens without an app id belong to the app of
+// the server.
+func (s *Server) Add(token string, info Token) {
+	if info.AppID == "" {
+		info.AppID = s.AppID
+	}
+	s.mu.Lock()
+	s.tokens[token] = info
+	s.mu.Unlock()
+}
+
+func (s *Server) lookup(token string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, found := s.tokens[token]
+	return info, found
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the api version in front of the path is ignored
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/debug_token"):
+		s.debugToken(w, r)
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/me"):
+		s.me(w, r)
+	default:
+		writeError(w, http.StatusNotFound, 803, "unknown path")
+	}
+}
+
+func (s *Server) debugToken(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("access_token") != s.AppID+"|"+s.AppSecret {
+		writeError(w, http.StatusBadRequest, 190, "invalid app access token")
+		return
+	}
+
+	debug := facebook.DebugToken{}
+	info, found := s.lookup(r.URL.Query().Get("input_token"))
+	if found {
+		debug = facebook.DebugToken{
+			AppID:     info.AppID,
+			Type:      "USER",
+			IsValid:   !info.Revoked,
+			ExpiresAt: info.ExpiresAt,
+			Scopes:    info.Scopes,
+			UserID:    info.UserID,
+		}
+	} else {
+		debug.Error = &facebook.Error{Code: 190, Message: "Invalid OAuth access token."}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": debug})
+}
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("access_token")
+	info, found := s.lookup(token)
+	if !found {
+		writeError(w, http.StatusBadRequest, 190, "Invalid OAuth access token.")
+		return
+	}
+	// only tokens of our app can be proven with our secret
+	if info.AppID == s.AppID && r.URL.Query().Get("appsecret_proof") != facebook.AppSecretProof(s.AppSecret, token) {
+		writeError(w, http.StatusBadRequest, 100, "Invalid appsecret_proof provided in the API argument")
+		return
+	}
+
+	me := facebook.Me{ID: info.UserID, Name: info.Name, Email: info.Email}
+	if info.MeID != "" {
+		me.ID = info.MeID
+	}
+	me.Picture.Data.URL = "https://example.com/" + me.ID + ".png"
+	writeJSON(w, http.StatusOK, me)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code int, message string) {
+	writeJSON(w, statusCode, map[string]interface{}{
+		"error": facebook.Error{Code: code, Type: "OAuthException", Message: message},
+	})
+}
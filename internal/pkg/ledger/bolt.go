@@ -0,0 +1,76 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var processedBucket = []byte("processed")
+
+// BoltLedger keeps the processed ids in a bbolt database. It needs its own
+// file, bbolt does not share a database between handles.
+type BoltLedger struct {
+	DB *bolt.DB
+}
+
+func NewBoltLedger(path string) (*BoltLedger, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(processedBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltLedger{DB: db}, nil
+}
+
+func (l *BoltLedger) Close() error {
+	return l.DB.Close()
+}
+
+func (l *BoltLedger) Claim(ctx context.Context, id string) (bool, error) {
+	claimed := false
+	err := l.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(processedBucket)
+		if bucket.Get([]byte(id)) != nil {
+			return nil
+		}
+
+		now := time.Now()
+		expired := [][]byte{}
+		err := bucket.ForEach(func(key, value []byte) error {
+			at, err := time.Parse(time.RFC3339, string(value))
+			if err == nil && now.Sub(at) > Retention {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		claimed = true
+		return bucket.Put([]byte(id), []byte(now.Format(time.RFC3339)))
+	})
+	return claimed, err
+}
+
+func (l *BoltLedger) Release(ctx context.Context, id string) error {
+	return l.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(processedBucket).Delete([]byte(id))
+	})
+}
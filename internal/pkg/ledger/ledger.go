@@ -0,0 +1,116 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Retention is how long processed ids are kept. Stripe stops retrying a
+// delivery after three days.
+const Retention = 30 * 24 * time.Hour
+
+// API records which webhook events were already processed, so retried
+// deliveries are handled only once.
+type API interface {
+	// Claim records id and reports false when it was claimed before.
+	Claim(ctx context.Context, id string) (bool, error)
+	// Release forgets id, so a delivery that could not be processed is
+	// handled again when it is retried.
+	Release(ctx context.Context, id string) error
+}
+
+// FileLedger keeps the processed ids in a JSON file, rewritten on every
+// change.
+type FileLedger struct {
+	Path string
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewFileLedger(path string) (*FileLedger, error) {
+	ledger := &FileLedger{
+		Path: path,
+		seen: map[string]time.Time{},
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &ledger.seen); err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+func (l *FileLedger) Claim(ctx context.Context, id string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.seen[id]; found {
+		return false, nil
+	}
+
+	now := time.Now()
+	for seenID, at := range l.seen {
+		if now.Sub(at) > Retention {
+			delete(l.seen, seenID)
+		}
+	}
+	l.seen[id] = now
+	if err := l.save(); err != nil {
+		delete(l.seen, id)
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *FileLedger) Release(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at, found := l.seen[id]
+	if !found {
+		return nil
+	}
+	delete(l.seen, id)
+	if err := l.save(); err != nil {
+		l.seen[id] = at
+		return err
+	}
+	return nil
+}
+
+// save writes a temporary file and renames it over the ledger, so a crash
+// never leaves a half written file behind.
+func (l *FileLedger) save() error {
+	content, err := json.Marshal(l.seen)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(l.Path), filepath.Base(l.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.Path)
+}
@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// newLedgers opens a FileLedger and a BoltLedger that already processed
+// the ids in seen at the given times.
+func newLedgers(t *testing.T, seen map[string]time.Time) map[string]API {
+	t.Helper()
+	dir := t.TempDir()
+
+	filePath := filepath.Join(dir, "webhook-events.json")
+	content, err := json.Marshal(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileLedger, err := NewFileLedger(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boltLedger, err := NewBoltLedger(filepath.Join(dir, "webhook-events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boltLedger.Close() })
+	err = boltLedger.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(processedBucket)
+		for id, at := range seen {
+			if err := bucket.Put([]byte(id), []byte(at.Format(time.RFC3339))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]API{"file": fileLedger, "bolt": boltLedger}
+}
+
+func claim(t *testing.T, ledger API, id string, want bool) {
+	t.Helper()
+	claimed, err := ledger.Claim(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed != want {
+		t.Errorf("Claim(%q) = %v, want %v", id, claimed, want)
+	}
+}
+
+func TestClaimAndRelease(t *testing.T) {
+	for name, ledger := range newLedgers(t, map[string]time.Time{}) {
+		t.Run(name, func(t *testing.T) {
+			claim(t, ledger, "evt_1", true)
+			claim(t, ledger, "evt_1", false)
+			claim(t, ledger, "evt_2", true)
+
+			if err := ledger.Release(context.Background(), "evt_1"); err != nil {
+				t.Fatal(err)
+			}
+			claim(t, ledger, "evt_1", true)
+			claim(t, ledger, "evt_2", false)
+
+			// releasing an unknown id is not an error
+			if err := ledger.Release(context.Background(), "evt_unknown"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Now()
+	seen := map[string]time.Time{
+		"evt_expired": now.Add(-Retention - time.Hour),
+		"evt_recent":  now.Add(-Retention + time.Hour),
+	}
+	for name, ledger := range newLedgers(t, seen) {
+		t.Run(name, func(t *testing.T) {
+			claim(t, ledger, "evt_recent", false)
+
+			// a new claim forgets the ids older than Retention
+			claim(t, ledger, "evt_new", true)
+			claim(t, ledger, "evt_expired", true)
+			claim(t, ledger, "evt_recent", false)
+		})
+	}
+}
+
+func TestFileLedgerReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook-events.json")
+	ledger, err := NewFileLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim(t, ledger, "evt_1", true)
+	claim(t, ledger, "evt_2", true)
+	if err := ledger.Release(context.Background(), "evt_2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim(t, reopened, "evt_1", false)
+	claim(t, reopened, "evt_2", true)
+}
+
+func TestBoltLedgerReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook-events.db")
+	ledger, err := NewBoltLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim(t, ledger, "evt_1", true)
+	if err := ledger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewBoltLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	claim(t, reopened, "evt_1", false)
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -32,17 +34,22 @@ func (l Level) String() string {
 	}
 }
 
+// StringToLevel reads a level that was checked before, unknown levels
+// are Basic.
 func StringToLevel(s string) Level {
-	switch s {
-	case Basic.String():
-		return Basic
-	case Medium.String():
-		return Medium
-	case Advanced.String():
-		return Advanced
-	default:
-		return Basic
+	level, _ := ParseLevel(s)
+	return level
+}
+
+// ParseLevel is like StringToLevel but rejects unknown levels. It ignores
+// case.
+func ParseLevel(s string) (Level, error) {
+	for _, level := range []Level{Basic, Medium, Advanced} {
+		if strings.EqualFold(s, level.String()) {
+			return level, nil
+		}
 	}
+	return Basic, fmt.Errorf("unknown level %q", s)
 }
 
 func TimeToID(date string) string {
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role grants access to parts of the API. Everyone in the member
+// spreadsheet is a member, the other roles are given in its roles column.
+type Role string
+
+const (
+	Member    Role = "member"
+	Organizer Role = "organizer"
+	Treasurer Role = "treasurer"
+	// Admin has every other role too.
+	Admin Role = "admin"
+)
+
+// ParseRole ignores case and rejects unknown roles.
+func ParseRole(s string) (Role, error) {
+	for _, role := range []Role{Member, Organizer, Treasurer, Admin} {
+		if strings.EqualFold(strings.TrimSpace(s), string(role)) {
+			return role, nil
+		}
+	}
+	return Member, fmt.Errorf("unknown role %q", s)
+}
+
+// ParseRoles reads a list of roles separated by commas, semicolons or
+// spaces. Unknown roles are left out and returned as errors.
+func ParseRoles(s string) ([]Role, []error) {
+	roles := []Role{}
+	errs := []error{}
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	}) {
+		role, err := ParseRole(field)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		roles = AddRole(roles, role)
+	}
+	return roles, errs
+}
+
+// AddRole appends role unless roles already has it.
+func AddRole(roles []Role, role Role) []Role {
+	for _, existing := range roles {
+		if existing == role {
+			return roles
+		}
+	}
+	return append(roles, role)
+}
+
+// HasRole reports whether roles grant role.
+func HasRole(roles []Role, role Role) bool {
+	for _, granted := range roles {
+		if granted == role || granted == Admin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+type Message struct {
+	To      string
+	Name    string
+	Subject string
+	Body    string
+}
+
+// API sends messages to members. Implementations must be safe for
+// concurrent use.
+type API interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// LogNotifier only writes the messages to the log. It is the default until
+// a mail server is configured.
+type LogNotifier struct {
+	Logger *logging.Logger
+}
+
+func NewLogNotifier(logger *logging.Logger) *LogNotifier {
+	return &LogNotifier{Logger: logger}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, message Message) error {
+	n.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message": "notification",
+			"to":      message.To,
+			"subject": message.Subject,
+			"body":    message.Body,
+		}},
+	)
+	return nil
+}
+
+// SMTPNotifier sends the messages as plain text emails.
+type SMTPNotifier struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func NewSMTPNotifier(host string, port string, username string, password string, from string) *SMTPNotifier {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPNotifier{
+		Addr: host + ":" + port,
+		Auth: auth,
+		From: from,
+	}
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, message Message) error {
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return fmt.Errorf("invalid header in message to %q", message.To)
+	}
+
+	to := message.To
+	if message.Name != "" {
+		to = fmt.Sprintf("%q <%s>", message.Name, message.To)
+	}
+
+	content := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		message.Body,
+	}, "\r\n")
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{message.To}, []byte(content))
+}
@@ -9,8 +9,10 @@ import (
 	"cloud.google.com/go/logging"
 	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
 	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
 	"github.com/stripe/stripe-go/v72/paymentlink"
 	"github.com/stripe/stripe-go/v72/price"
+	"github.com/stripe/stripe-go/v72/refund"
 )
 
 const (
@@ -33,6 +35,8 @@ type API interface {
 	CreatePayment(ctx context.Context, price int64, event string, user spreadsheet.User) (string, error)
 	CreatePrice(ctx context.Context, price int64) (*stripe.Price, error)
 	GetPrice(ctx context.Context, price int64) (*stripe.Price, error)
+	GetCheckoutSession(ctx context.Context, paymentIntentID string) (*stripe.CheckoutSession, error)
+	Refund(ctx context.Context, paymentIntentID string, amount int64, idempotencyKey string) (string, error)
 }
 
 func New(apiKey string, productID string, logger *logging.Logger) API {
@@ -118,3 +122,37 @@ func (c *Client) GetPrice(ctx context.Context, objPrice int64) (*stripe.Price, e
 	}
 	return nil, ErrNotFound
 }
+
+// GetCheckoutSession returns the checkout session that created the payment
+// intent, charges and payment intents do not carry the session metadata.
+func (c *Client) GetCheckoutSession(ctx context.Context, paymentIntentID string) (*stripe.CheckoutSession, error) {
+	params := &stripe.CheckoutSessionListParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+	}
+	iter := session.List(params)
+	for iter.Next() {
+		return iter.CheckoutSession(), nil
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotFound
+}
+
+// Refund returns amount öre of the payment intent and returns the refund
+// id. Calls with the same idempotency key refund only once.
+func (c *Client) Refund(ctx context.Context, paymentIntentID string, amount int64, idempotencyKey string) (string, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+	}
+	if amount > 0 {
+		params.Amount = stripe.Int64(amount)
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	r, err := refund.New(params)
+	if err != nil {
+		return "", err
+	}
+	return r.ID, nil
+}
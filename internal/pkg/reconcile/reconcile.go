@@ -0,0 +1,294 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+)
+
+// paymentWindow is how long before or after a session payments for it
+// are looked for.
+const paymentWindow = 60 * 24 * time.Hour
+
+var datePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+// Client matches the transactions of a Swish statement to the sessions
+// and members they pay for. The QR code message is "<LEVEL> <DATE> <NAME>",
+// older codes leave the name out.
+type Client struct {
+	Calendar calendar.API
+	Members  spreadsheet.API
+	Logger   *logging.Logger
+}
+
+type API interface {
+	Import(ctx context.Context, transactions []swish.Transaction, dryRun bool) (*Report, error)
+}
+
+func New(calendarService calendar.API, spreadsheetService spreadsheet.API, logger *logging.Logger) API {
+	return &Client{
+		Calendar: calendarService,
+		Members:  spreadsheetService,
+		Logger:   logger,
+	}
+}
+
+// Report lists what an import did. With DryRun nothing was recorded.
+type Report struct {
+	DryRun    bool        `json:"dry_run"`
+	Matched   []Match     `json:"matched"`
+	Unmatched []Unmatched `json:"unmatched"`
+	Unpaid    []Unpaid    `json:"unpaid"`
+}
+
+type Match struct {
+	swish.Transaction
+	EventID   string `json:"event_id"`
+	EventName string `json:"event_name"`
+	Email     string `json:"email"`
+}
+
+type Unmatched struct {
+	swish.Transaction
+	Reason string `json:"reason"`
+}
+
+// Unpaid is an attendee of a session in the statement period without a
+// confirmed payment.
+type Unpaid struct {
+	EventID       string    `json:"event_id"`
+	EventName     string    `json:"event_name"`
+	Date          time.Time `json:"date"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	PaymentStatus string    `json:"payment_status,omitempty"`
+}
+
+// Import confirms the payments of the matched transactions and reports
+// the transactions it could not match and the attendees still unpaid.
+func (c *Client) Import(ctx context.Context, transactions []swish.Transaction, dryRun bool) (*Report, error) {
+	report := &Report{
+		DryRun:    dryRun,
+		Matched:   []Match{},
+		Unmatched: []Unmatched{},
+		Unpaid:    []Unpaid{},
+	}
+	if len(transactions) == 0 {
+		return report, nil
+	}
+
+	members, err := c.Members.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := transactions[0].Date, transactions[0].Date
+	for _, transaction := range transactions {
+		if transaction.Date.Before(first) {
+			first = transaction.Date
+		}
+		if transaction.Date.After(last) {
+			last = transaction.Date
+		}
+	}
+	events, err := c.listEvents(ctx, first.Add(-paymentWindow), last.Add(paymentWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, transaction := range transactions {
+		index, date, reason := matchEvent(events, transaction)
+		if index < 0 {
+			report.Unmatched = append(report.Unmatched, Unmatched{Transaction: transaction, Reason: reason})
+			continue
+		}
+		member, reason := matchMember(members, transaction, date)
+		if member == nil {
+			report.Unmatched = append(report.Unmatched, Unmatched{Transaction: transaction, Reason: reason})
+			continue
+		}
+
+		payment := calendar.Payment{
+			Email:         member.Email,
+			PaidTimestamp: transaction.Date,
+			Method:        calendar.MethodSwish,
+			Status:        calendar.PaymentConfirmed,
+			Amount:        transaction.Amount,
+			Reference:     transaction.Reference,
+		}
+		if dryRun {
+			events[index].Payments, _ = events[index].Payments.Confirm(payment)
+		} else {
+			updated, err := c.Calendar.ConfirmPayment(ctx, events[index].ID, payment)
+			if err != nil {
+				c.Logger.Log(logging.Entry{
+					Severity: logging.Error,
+					Payload: map[string]interface{}{
+						"message":     "could not confirm payment",
+						"event":       events[index].ID,
+						"user":        member.Email,
+						"transaction": transaction,
+						"error":       err,
+					}},
+				)
+				report.Unmatched = append(report.Unmatched, Unmatched{
+					Transaction: transaction,
+					Reason:      fmt.Sprintf("could not record payment: %v", err),
+				})
+				continue
+			}
+			events[index] = updated
+		}
+
+		report.Matched = append(report.Matched, Match{
+			Transaction: transaction,
+			EventID:     events[index].ID,
+			EventName:   events[index].Name,
+			Email:       member.Email,
+		})
+	}
+
+	periodStart := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, first.Location())
+	for _, event := range events {
+		if event.Price <= 0 || event.Cancelled || event.Date.Before(periodStart) || event.Date.After(last) {
+			continue
+		}
+		for _, attendee := range event.Attendees {
+			status := event.PaymentStatusOf(attendee.Email)
+			if status == calendar.PaymentConfirmed {
+				continue
+			}
+			report.Unpaid = append(report.Unpaid, Unpaid{
+				EventID:       event.ID,
+				EventName:     event.Name,
+				Date:          event.Date,
+				Name:          attendee.Name,
+				Email:         attendee.Email,
+				PaymentStatus: status,
+			})
+		}
+	}
+
+	c.Logger.Log(logging.Entry{
+		Severity: logging.Info,
+		Payload: map[string]interface{}{
+			"message":   "imported swish statement",
+			"dry_run":   dryRun,
+			"matched":   len(report.Matched),
+			"unmatched": len(report.Unmatched),
+			"unpaid":    len(report.Unpaid),
+		}},
+	)
+	return report, nil
+}
+
+func (c *Client) listEvents(ctx context.Context, from time.Time, to time.Time) ([]*calendar.Event, error) {
+	filter := calendar.EventFilter{
+		EventQuery: calendar.EventQuery{From: from, To: to, Limit: 250},
+	}
+	events := []*calendar.Event{}
+	for {
+		page, err := c.Calendar.GetEvents(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page.Events...)
+		if page.NextPageToken == "" {
+			return events, nil
+		}
+		filter.PageToken = page.NextPageToken
+	}
+}
+
+// matchEvent finds the session the message and amount of transaction are
+// for. It returns the index of the event and the date in the message, or
+// -1 and why no single session matches.
+func matchEvent(events []*calendar.Event, transaction swish.Transaction) (int, string, string) {
+	date := datePattern.FindString(transaction.Message)
+	if date == "" {
+		return -1, "", "message has no session date"
+	}
+
+	level := ""
+	for _, word := range strings.Fields(transaction.Message[:strings.Index(transaction.Message, date)]) {
+		if parsed, err := model.ParseLevel(word); err == nil {
+			level = parsed.String()
+		}
+	}
+
+	found := -1
+	for index, event := range events {
+		if event.Date.Format(model.DateLayout) != date {
+			continue
+		}
+		if level != "" && !strings.EqualFold(event.Level, level) {
+			continue
+		}
+		if int64(event.Price)*100 != transaction.Amount {
+			continue
+		}
+		if found >= 0 {
+			return -1, date, fmt.Sprintf("several sessions on %s match", date)
+		}
+		found = index
+	}
+	if found < 0 {
+		return -1, date, fmt.Sprintf("no session on %s costs this amount", date)
+	}
+	return found, date, ""
+}
+
+// matchMember finds the payer by phone number, then by the payer name and
+// last by the name in the message.
+func matchMember(members []spreadsheet.User, transaction swish.Transaction, date string) (*spreadsheet.User, string) {
+	if phone := swish.NormalizePhone(transaction.Phone); phone != "" {
+		for index := range members {
+			if swish.NormalizePhone(members[index].Phone) == phone {
+				return &members[index], ""
+			}
+		}
+	}
+
+	names := []string{transaction.Name}
+	if index := strings.Index(transaction.Message, date); date != "" && index >= 0 {
+		names = append(names, transaction.Message[index+len(date):])
+	}
+	for _, name := range names {
+		name = normalizeName(name)
+		if name == "" {
+			continue
+		}
+
+		var found *spreadsheet.User
+		for index := range members {
+			if normalizeName(members[index].Name) != name {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Sprintf("several members are called %s", name)
+			}
+			found = &members[index]
+		}
+		if found != nil {
+			return found, ""
+		}
+	}
+	return nil, "payer is not a member"
+}
+
+// normalizeName turns "Last, First" into "first last", banks write payer
+// names either way.
+func normalizeName(name string) string {
+	if parts := strings.SplitN(name, ",", 2); len(parts) == 2 {
+		name = parts[1] + " " + parts[0]
+	}
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
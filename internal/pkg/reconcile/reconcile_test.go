@@ -0,0 +1,206 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/calendar"
+	"github.com/stockholmfootvolley/booking/internal/pkg/spreadsheet"
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// testLogger logs nowhere. Entries are buffered and never delivered.
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	client, err := logging.NewClient(context.Background(), "projects/test",
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client.Logger("test")
+}
+
+func day(date string, hour int) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02", date, time.Local)
+	return t.Add(time.Duration(hour) * time.Hour)
+}
+
+func TestMatchEvent(t *testing.T) {
+	events := []*calendar.Event{
+		{ID: "basic", Date: day("2024-01-09", 18), Level: "BASIC", Price: 100},
+		{ID: "advanced", Date: day("2024-01-09", 20), Level: "ADVANCED", Price: 100},
+		{ID: "expensive", Date: day("2024-01-10", 18), Level: "BASIC", Price: 150},
+		{ID: "twin1", Date: day("2024-01-11", 18), Level: "MEDIUM", Price: 100},
+		{ID: "twin2", Date: day("2024-01-11", 20), Level: "MEDIUM", Price: 100},
+	}
+
+	tests := []struct {
+		message string
+		amount  int64
+		want    string
+		reason  string
+	}{
+		{"BASIC 2024-01-09 Anna Andersson", 10000, "basic", ""},
+		{"advanced 2024-01-09", 10000, "advanced", ""},
+		{"Träning 2024-01-10", 15000, "expensive", ""},
+		{"2024-01-09 Anna Andersson", 10000, "", "several sessions on 2024-01-09 match"},
+		{"MEDIUM 2024-01-11", 10000, "", "several sessions on 2024-01-11 match"},
+		{"BASIC 2024-01-10", 10000, "", "no session on 2024-01-10 costs this amount"},
+		{"BASIC 2024-01-12", 10000, "", "no session on 2024-01-12 costs this amount"},
+		{"training", 10000, "", "message has no session date"},
+	}
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			index, _, reason := matchEvent(events, swish.Transaction{Message: test.message, Amount: test.amount})
+			got := ""
+			if index >= 0 {
+				got = events[index].ID
+			}
+			if got != test.want || reason != test.reason {
+				t.Errorf("got %q (%q), want %q (%q)", got, reason, test.want, test.reason)
+			}
+		})
+	}
+}
+
+func TestMatchMember(t *testing.T) {
+	members := []spreadsheet.User{
+		{Name: "Anna Andersson", Email: "anna@example.com", Phone: "070-123 45 67"},
+		{Name: "Bo Berg", Email: "bo@example.com"},
+		{Name: "Carl Carlsson", Email: "carl1@example.com"},
+		{Name: "Carl  Carlsson", Email: "carl2@example.com"},
+	}
+
+	tests := []struct {
+		name        string
+		transaction swish.Transaction
+		want        string
+		reason      string
+	}{
+		{"phone", swish.Transaction{Phone: "+46701234567", Name: "Someone Else"}, "anna@example.com", ""},
+		{"national phone", swish.Transaction{Phone: "0701234567"}, "anna@example.com", ""},
+		{"name", swish.Transaction{Name: "ANNA  ANDERSSON"}, "anna@example.com", ""},
+		{"last name first", swish.Transaction{Name: "Andersson, Anna"}, "anna@example.com", ""},
+		{"unknown phone", swish.Transaction{Phone: "0709999999", Name: "Bo Berg"}, "bo@example.com", ""},
+		{"message", swish.Transaction{Name: "Berg Holding AB", Message: "BASIC 2024-01-09 Bo Berg"}, "bo@example.com", ""},
+		{"ambiguous", swish.Transaction{Name: "Carlsson, Carl"}, "", "several members are called carl carlsson"},
+		{"not a member", swish.Transaction{Name: "Someone Else", Message: "2024-01-09"}, "", "payer is not a member"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			date := datePattern.FindString(test.transaction.Message)
+			member, reason := matchMember(members, test.transaction, date)
+			got := ""
+			if member != nil {
+				got = member.Email
+			}
+			if got != test.want || reason != test.reason {
+				t.Errorf("got %q (%q), want %q (%q)", got, reason, test.want, test.reason)
+			}
+		})
+	}
+}
+
+// fakeCalendar serves events from memory, the rest of calendar.API is
+// not used by Import.
+type fakeCalendar struct {
+	calendar.API
+	events   []*calendar.Event
+	confirms int
+}
+
+func (f *fakeCalendar) GetEvents(ctx context.Context, filter calendar.EventFilter) (*calendar.EventPage, error) {
+	page := &calendar.EventPage{}
+	for _, event := range f.events {
+		event := *event
+		event.Payments = append(calendar.Payments{}, event.Payments...)
+		page.Events = append(page.Events, &event)
+	}
+	return page, nil
+}
+
+func (f *fakeCalendar) ConfirmPayment(ctx context.Context, eventID string, payment calendar.Payment) (*calendar.Event, error) {
+	f.confirms++
+	for _, event := range f.events {
+		if event.ID == eventID {
+			event.Payments, _ = event.Payments.Confirm(payment)
+			confirmed := *event
+			return &confirmed, nil
+		}
+	}
+	return nil, calendar.ErrEventNotFound
+}
+
+type fakeMembers struct {
+	spreadsheet.API
+	members []spreadsheet.User
+}
+
+func (f *fakeMembers) GetUsers() ([]spreadsheet.User, error) {
+	return f.members, nil
+}
+
+func TestImport(t *testing.T) {
+	transactions := []swish.Transaction{
+		{Date: day("2024-01-09", 9), Amount: 10000, Message: "BASIC 2024-01-09", Name: "Andersson, Anna", Reference: "bank-1"},
+		{Date: day("2024-01-10", 9), Amount: 10000, Message: "BASIC 2024-01-09", Name: "Someone Else", Reference: "bank-2"},
+		{Date: day("2024-01-12", 9), Amount: 10000, Message: "tack", Name: "Bo Berg", Reference: "bank-3"},
+	}
+	members := &fakeMembers{members: []spreadsheet.User{
+		{Name: "Anna Andersson", Email: "anna@example.com"},
+		{Name: "Bo Berg", Email: "bo@example.com"},
+	}}
+
+	for _, dryRun := range []bool{true, false} {
+		calendarService := &fakeCalendar{events: []*calendar.Event{{
+			ID:    "basic",
+			Name:  "Training",
+			Date:  day("2024-01-09", 18),
+			Level: "BASIC",
+			Price: 100,
+			Attendees: []calendar.Attendee{
+				{Name: "Anna Andersson", Email: "anna@example.com"},
+				{Name: "Bo Berg", Email: "bo@example.com"},
+			},
+			Payments: calendar.Payments{{Email: "anna@example.com", Method: calendar.MethodSwish, Status: calendar.PaymentClaimed}},
+		}}}
+
+		report, err := New(calendarService, members, testLogger(t)).Import(context.Background(), transactions, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Report{
+			DryRun:  dryRun,
+			Matched: []Match{{Transaction: transactions[0], EventID: "basic", EventName: "Training", Email: "anna@example.com"}},
+			Unmatched: []Unmatched{
+				{Transaction: transactions[1], Reason: "payer is not a member"},
+				{Transaction: transactions[2], Reason: "message has no session date"},
+			},
+			Unpaid: []Unpaid{{EventID: "basic", EventName: "Training", Date: day("2024-01-09", 18), Name: "Bo Berg", Email: "bo@example.com"}},
+		}
+		if !reflect.DeepEqual(report, want) {
+			t.Errorf("dry run %v: got\n%+v\nwant\n%+v", dryRun, report, want)
+		}
+
+		wantConfirms, wantStatus := 1, calendar.PaymentConfirmed
+		if dryRun {
+			wantConfirms, wantStatus = 0, calendar.PaymentClaimed
+		}
+		if calendarService.confirms != wantConfirms {
+			t.Errorf("dry run %v: confirmed %d payments, want %d", dryRun, calendarService.confirms, wantConfirms)
+		}
+		if status := calendarService.events[0].PaymentStatusOf("anna@example.com"); status != wantStatus {
+			t.Errorf("dry run %v: got stored status %q, want %q", dryRun, status, wantStatus)
+		}
+	}
+}
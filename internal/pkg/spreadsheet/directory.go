@@ -0,0 +1,149 @@
+package spreadsheet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+)
+
+const DefaultTTL = 5 * time.Minute
+
+// Directory caches the member list of a Reader, so authenticated
+// requests do not each read the sheet. Members older than TTL are still
+// served while they are read again in the background, and when the sheet
+// cannot be read the last list read keeps being served.
+type Directory struct {
+	Source Reader
+	TTL    time.Duration
+	Logger *logging.Logger
+
+	// loading serializes reads of the source
+	loading    sync.Mutex
+	mu         sync.Mutex
+	users      []User
+	invalid    []RowError
+	loaded     time.Time
+	refreshing bool
+}
+
+func NewDirectory(source Reader, ttl time.Duration, logger *logging.Logger) *Directory {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Directory{
+		Source: source,
+		TTL:    ttl,
+		Logger: logger,
+	}
+}
+
+// Start reloads the members every TTL until ctx is done.
+func (d *Directory) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.TTL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.Reload()
+			}
+		}
+	}()
+}
+
+func (d *Directory) GetUsers() ([]User, error) {
+	d.mu.Lock()
+	users, loaded := d.users, d.loaded
+	stale := users != nil && time.Since(loaded) > d.TTL && !d.refreshing
+	if stale {
+		d.refreshing = true
+	}
+	d.mu.Unlock()
+
+	if users == nil {
+		users, _, err := d.load(loaded)
+		return users, err
+	}
+	if stale {
+		go func() {
+			d.Reload()
+			d.mu.Lock()
+			d.refreshing = false
+			d.mu.Unlock()
+		}()
+	}
+	return copyUsers(users), nil
+}
+
+func (d *Directory) GetUser(email string) (*User, error) {
+	users, err := d.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	return findUser(users, email)
+}
+
+func (d *Directory) Reload() (*Report, error) {
+	users, invalid, err := d.load(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Report{Members: len(users), Invalid: invalid}, nil
+}
+
+// load reads the source unless another caller did since after.
+func (d *Directory) load(after time.Time) ([]User, []RowError, error) {
+	d.loading.Lock()
+	defer d.loading.Unlock()
+
+	d.mu.Lock()
+	if d.users != nil && d.loaded.After(after) {
+		users, invalid := d.users, d.invalid
+		d.mu.Unlock()
+		return copyUsers(users), invalid, nil
+	}
+	d.mu.Unlock()
+
+	users, invalid, err := d.Source.ReadUsers()
+	if err != nil {
+		d.mu.Lock()
+		cached, loaded := d.users, d.loaded
+		d.mu.Unlock()
+
+		d.Logger.Log(logging.Entry{
+			Severity: logging.Error,
+			Payload: map[string]interface{}{
+				"message":      "could not reload members, serving the last list read",
+				"members":      len(cached),
+				"members_from": loaded,
+				"error":        err,
+			}},
+		)
+		if cached == nil {
+			return nil, nil, err
+		}
+		return copyUsers(cached), nil, err
+	}
+
+	d.mu.Lock()
+	d.users = users
+	d.invalid = invalid
+	d.loaded = time.Now()
+	d.mu.Unlock()
+	return copyUsers(users), invalid, nil
+}
+
+// copyUsers keeps callers from changing the cached members.
+func copyUsers(users []User) []User {
+	copied := make([]User, len(users))
+	for index, user := range users {
+		user.Roles = append([]model.Role(nil), user.Roles...)
+		copied[index] = user
+	}
+	return copied
+}
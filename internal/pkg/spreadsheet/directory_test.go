@@ -0,0 +1,224 @@
+package spreadsheet
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+)
+
+// fakeReader serves users, or fails while err is set, and counts the
+// reads.
+type fakeReader struct {
+	mu      sync.Mutex
+	users   []User
+	invalid []RowError
+	err     error
+	reads   int
+}
+
+func (f *fakeReader) ReadUsers() ([]User, []RowError, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reads++
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	return append([]User(nil), f.users...), f.invalid, nil
+}
+
+func (f *fakeReader) set(users []User, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users, f.err = users, err
+}
+
+func (f *fakeReader) readCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads
+}
+
+var (
+	anna = User{Name: "Anna Andersson", Email: "anna@example.com", Roles: []model.Role{model.Member}}
+	bo   = User{Name: "Bo Berg", Email: "bo@example.com", Roles: []model.Role{model.Member}}
+)
+
+func newTestDirectory(t *testing.T, users ...User) (*Directory, *fakeReader) {
+	source := &fakeReader{users: users}
+	return NewDirectory(source, time.Hour, testLogger(t)), source
+}
+
+// expire makes the cached members older than the TTL.
+func expire(d *Directory) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loaded = time.Now().Add(-2 * d.TTL)
+}
+
+// waitForRefresh waits until the background reload started by GetUsers
+// is done.
+func waitForRefresh(t *testing.T, d *Directory) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		refreshing := d.refreshing
+		d.mu.Unlock()
+		if !refreshing {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("members were not reloaded")
+}
+
+func emails(users []User) []string {
+	emails := []string{}
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+	return emails
+}
+
+func wantEmails(t *testing.T, users []User, want ...string) {
+	t.Helper()
+	got := emails(users)
+	if len(got) != len(want) {
+		t.Fatalf("got members %q, want %q", got, want)
+	}
+	for index := range got {
+		if got[index] != want[index] {
+			t.Fatalf("got members %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDirectoryCachesMembers(t *testing.T) {
+	directory, source := newTestDirectory(t, anna)
+
+	for i := 0; i < 3; i++ {
+		users, err := directory.GetUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantEmails(t, users, anna.Email)
+	}
+	if _, err := directory.GetUser("ANNA@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if reads := source.readCount(); reads != 1 {
+		t.Errorf("read the sheet %d times, want once", reads)
+	}
+
+	// callers cannot change the cache
+	users, _ := directory.GetUsers()
+	users[0].Roles[0] = model.Admin
+	user, _ := directory.GetUser(anna.Email)
+	if model.HasRole(user.Roles, model.Admin) {
+		t.Errorf("changing a member changed the cache: %+v", user)
+	}
+}
+
+func TestDirectoryServesStaleMembers(t *testing.T) {
+	directory, source := newTestDirectory(t, anna)
+	if _, err := directory.GetUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	source.set([]User{anna, bo}, nil)
+	expire(directory)
+
+	// the stale list is answered right away and read again meanwhile
+	users, err := directory.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEmails(t, users, anna.Email)
+
+	waitForRefresh(t, directory)
+	users, err = directory.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEmails(t, users, anna.Email, bo.Email)
+	if reads := source.readCount(); reads != 2 {
+		t.Errorf("read the sheet %d times, want twice", reads)
+	}
+}
+
+func TestDirectoryKeepsLastMembersOnFailure(t *testing.T) {
+	directory, source := newTestDirectory(t, anna)
+	if _, err := directory.GetUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("sheet unavailable")
+	source.set(nil, failure)
+
+	if _, err := directory.Reload(); !errors.Is(err, failure) {
+		t.Fatalf("got %v, want the read error", err)
+	}
+	users, err := directory.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEmails(t, users, anna.Email)
+
+	// a failed background reload keeps the stale list too
+	expire(directory)
+	if _, err := directory.GetUsers(); err != nil {
+		t.Fatal(err)
+	}
+	waitForRefresh(t, directory)
+	users, err = directory.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEmails(t, users, anna.Email)
+
+	source.set([]User{bo}, nil)
+	report, err := directory.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Members != 1 {
+		t.Errorf("got report %+v, want one member", report)
+	}
+	users, _ = directory.GetUsers()
+	wantEmails(t, users, bo.Email)
+}
+
+func TestDirectoryFailsWithoutMembers(t *testing.T) {
+	directory, source := newTestDirectory(t)
+	failure := errors.New("sheet unavailable")
+	source.set(nil, failure)
+
+	if _, err := directory.GetUsers(); !errors.Is(err, failure) {
+		t.Fatalf("got %v, want the read error", err)
+	}
+
+	// the next request tries again
+	source.set([]User{anna}, nil)
+	users, err := directory.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEmails(t, users, anna.Email)
+}
+
+func TestDirectoryReloadReport(t *testing.T) {
+	directory, source := newTestDirectory(t, anna)
+	source.invalid = []RowError{{Row: 3, Field: FieldEmail, Reason: "missing email"}}
+
+	report, err := directory.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Members != 1 || len(report.Invalid) != 1 || report.Invalid[0].Row != 3 {
+		t.Errorf("got report %+v", report)
+	}
+}
@@ -0,0 +1,102 @@
+package spreadsheet
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+// File reads the members from a CSV file, like the member sheet exported
+// from Google Sheets or Excel, for clubs that do not use Google. The
+// columns are found by their header names, like in the sheet.
+type File struct {
+	Path    string
+	Columns map[string][]string
+	Logger  *logging.Logger
+}
+
+func NewFile(path string, columns map[string][]string, logger *logging.Logger) *File {
+	if columns == nil {
+		columns = DefaultColumns
+	}
+	return &File{
+		Path:    path,
+		Columns: columns,
+		Logger:  logger,
+	}
+}
+
+// ReadUsers reads the whole file. It is read again on every call, so
+// members can be edited while the server runs.
+func (f *File) ReadUsers() ([]User, []RowError, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	rows, err := readCSV(bufio.NewReader(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, errors.New("member file has no header")
+	}
+
+	users, invalid := ParseRows(rows, Schema{Columns: f.Columns})
+	if len(invalid) > 0 {
+		f.Logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload: map[string]interface{}{
+				"message": "member file has malformed rows",
+				"rows":    invalid,
+			}},
+		)
+	}
+	return users, invalid, nil
+}
+
+// readCSV reads comma or semicolon separated rows, Swedish spreadsheet
+// programs export the latter.
+func readCSV(reader *bufio.Reader) ([][]interface{}, error) {
+	first, err := reader.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	line := string(first)
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	records.TrimLeadingSpace = true
+	if strings.Count(line, ";") > strings.Count(line, ",") {
+		records.Comma = ';'
+	}
+
+	rows := [][]interface{}{}
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 && len(record) > 0 {
+			// excel starts its UTF-8 files with a byte order mark
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+		}
+		row := make([]interface{}, len(record))
+		for index, value := range record {
+			row[index] = value
+		}
+		rows = append(rows, row)
+	}
+}
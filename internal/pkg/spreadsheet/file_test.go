@@ -0,0 +1,83 @@
+package spreadsheet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// testLogger logs nowhere. Entries are buffered and never delivered.
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	client, err := logging.NewClient(context.Background(), "projects/test",
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client.Logger("test")
+}
+
+func writeMembers(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "members.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileReadUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"comma", "Name,Email,Level\nMember,member@example.com,ADVANCED\n"},
+		{"semicolon", "Namn;E-post;Nivå\nMember;member@example.com;advanced\n"},
+		{"byte order mark", "\ufeffEmail,Name,Level\nmember@example.com,Member,ADVANCED\n"},
+		{"quoted", "Name,Email,Level\n\"Member, Jr\",member@example.com,ADVANCED\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := NewFile(writeMembers(t, test.content), nil, testLogger(t))
+
+			users, invalid, err := file.ReadUsers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(invalid) > 0 {
+				t.Errorf("got invalid rows %v", invalid)
+			}
+			if len(users) != 1 || users[0].Email != "member@example.com" || users[0].Level != model.Advanced {
+				t.Fatalf("got %+v", users)
+			}
+		})
+	}
+}
+
+func TestFileReadUsersErrors(t *testing.T) {
+	if _, _, err := NewFile(filepath.Join(t.TempDir(), "missing.csv"), nil, testLogger(t)).ReadUsers(); err == nil {
+		t.Error("read a missing file")
+	}
+	if _, _, err := NewFile(writeMembers(t, ""), nil, testLogger(t)).ReadUsers(); err == nil {
+		t.Error("read an empty file")
+	}
+
+	file := NewFile(writeMembers(t, "Name,Email\nMember,member@example.com\nNo Email,\n"), nil, testLogger(t))
+	users, invalid, err := file.ReadUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || len(invalid) != 1 || invalid[0].Row != 3 || invalid[0].Field != FieldEmail {
+		t.Errorf("got users %+v and invalid rows %+v", users, invalid)
+	}
+}
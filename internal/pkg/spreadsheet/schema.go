@@ -0,0 +1,278 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+)
+
+// Fields of User that can be read from the sheet.
+const (
+	FieldName       = "name"
+	FieldEmail      = "email"
+	FieldLevel      = "level"
+	FieldPhone      = "phone"
+	FieldRoles      = "roles"
+	FieldMembership = "membership"
+	FieldJoined     = "joined"
+	FieldExpires    = "expires"
+)
+
+const DefaultRange = "Sheet1!A:Z"
+
+// DefaultColumns are the header names looked for, in lower case.
+var DefaultColumns = map[string][]string{
+	FieldName:       {"name", "namn"},
+	FieldEmail:      {"email", "e-mail", "e-post", "epost", "mail"},
+	FieldLevel:      {"level", "nivå", "niva"},
+	FieldPhone:      {"phone", "telefon", "mobil", "mobilnummer"},
+	FieldRoles:      {"roles", "role", "roller", "roll"},
+	FieldMembership: {"membership", "membership type", "medlemskap", "medlemstyp"},
+	FieldJoined:     {"joined", "join date", "member since", "medlem sedan", "startdatum"},
+	FieldExpires:    {"expires", "expiry date", "valid until", "giltig till", "slutdatum"},
+}
+
+// legacyColumns is the layout of sheets without a recognized header.
+var legacyColumns = map[string]int{
+	FieldName:  0,
+	FieldEmail: 1,
+	FieldLevel: 2,
+	FieldPhone: 3,
+	FieldRoles: 4,
+}
+
+var dateLayouts = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"}
+
+// Schema tells where the members are in the sheet.
+type Schema struct {
+	Range string
+	// Columns maps fields to the header names of their column.
+	Columns map[string][]string
+}
+
+// ParseColumns reads mappings like "email=E-post". A field may be given
+// several times, and replaces the default header names for that field.
+func ParseColumns(mappings []string) (map[string][]string, error) {
+	columns := map[string][]string{}
+	for field, names := range DefaultColumns {
+		columns[field] = names
+	}
+
+	configured := map[string]bool{}
+	for _, mapping := range mappings {
+		if strings.TrimSpace(mapping) == "" {
+			continue
+		}
+		parts := strings.SplitN(mapping, "=", 2)
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, want field=header", mapping)
+		}
+		if _, known := DefaultColumns[field]; !known {
+			return nil, fmt.Errorf("unknown member field %q", field)
+		}
+		if !configured[field] {
+			configured[field] = true
+			columns[field] = nil
+		}
+		columns[field] = append(columns[field], strings.ToLower(strings.TrimSpace(parts[1])))
+	}
+	return columns, nil
+}
+
+// RowError tells which row of the sheet is malformed. Rows with an error
+// on the email are left out, the others are read with defaults.
+type RowError struct {
+	Row    int    `json:"row"`
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e RowError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Reason)
+	}
+	return fmt.Sprintf("row %d, %s: %s", e.Row, e.Field, e.Reason)
+}
+
+// ParseRows reads the members of values, the cells read from the range of
+// schema. The header row is found by its names; without one the columns
+// are name, email, level, phone and roles under a title row.
+func ParseRows(values [][]interface{}, schema Schema) ([]User, []RowError) {
+	firstRow := startRow(schema.Range)
+	columns, headerIndex := findHeader(values, schema.Columns)
+	if columns == nil {
+		columns, headerIndex = legacyColumns, 0
+	}
+
+	users := []User{}
+	invalid := []RowError{}
+	seen := map[string]int{}
+	for index := headerIndex + 1; index < len(values); index++ {
+		row := values[index]
+		rowNumber := firstRow + index
+		if blank(row) {
+			continue
+		}
+
+		user, errs := parseRow(row, columns, rowNumber)
+		invalid = append(invalid, errs...)
+		if user == nil {
+			continue
+		}
+
+		key := strings.ToLower(user.Email)
+		if first, found := seen[key]; found {
+			invalid = append(invalid, RowError{
+				Row:    rowNumber,
+				Field:  FieldEmail,
+				Reason: fmt.Sprintf("%s is already on row %d", user.Email, first),
+			})
+			continue
+		}
+		seen[key] = rowNumber
+		users = append(users, *user)
+	}
+	return users, invalid
+}
+
+// findHeader returns the columns of the first row naming an email column,
+// and the index of that row.
+func findHeader(values [][]interface{}, names map[string][]string) (map[string]int, int) {
+	for index, row := range values {
+		columns := map[string]int{}
+		for position := range row {
+			header := strings.ToLower(cell(row, position))
+			for field, aliases := range names {
+				if _, found := columns[field]; found {
+					continue
+				}
+				for _, alias := range aliases {
+					if header == alias {
+						columns[field] = position
+					}
+				}
+			}
+		}
+		if _, found := columns[FieldEmail]; found {
+			return columns, index
+		}
+	}
+	return nil, 0
+}
+
+func parseRow(row []interface{}, columns map[string]int, rowNumber int) (*User, []RowError) {
+	value := func(field string) string {
+		position, found := columns[field]
+		if !found {
+			return ""
+		}
+		return cell(row, position)
+	}
+	invalid := []RowError{}
+	fail := func(field string, reason string) {
+		invalid = append(invalid, RowError{Row: rowNumber, Field: field, Reason: reason})
+	}
+
+	email := value(FieldEmail)
+	if email == "" {
+		fail(FieldEmail, "missing email")
+		return nil, invalid
+	}
+	if !strings.Contains(email, "@") {
+		fail(FieldEmail, fmt.Sprintf("invalid email %q", email))
+		return nil, invalid
+	}
+
+	user := &User{
+		Name:       value(FieldName),
+		Email:      email,
+		Phone:      value(FieldPhone),
+		Membership: value(FieldMembership),
+	}
+
+	if level := value(FieldLevel); level != "" {
+		parsed, err := model.ParseLevel(level)
+		if err != nil {
+			fail(FieldLevel, err.Error())
+		}
+		user.Level = parsed
+	}
+
+	roles, errs := model.ParseRoles(value(FieldRoles))
+	for _, err := range errs {
+		fail(FieldRoles, err.Error())
+	}
+	user.Roles = model.AddRole(roles, model.Member)
+
+	dates := []struct {
+		field string
+		date  **time.Time
+	}{
+		{FieldJoined, &user.JoinedAt},
+		{FieldExpires, &user.ExpiresAt},
+	}
+	for _, date := range dates {
+		text := value(date.field)
+		if text == "" {
+			continue
+		}
+		parsed, err := parseDate(text)
+		if err != nil {
+			fail(date.field, err.Error())
+			continue
+		}
+		*date.date = &parsed
+	}
+
+	return user, invalid
+}
+
+// cell reads a cell as text. The Sheets API answers numbers as numbers
+// when they are not formatted.
+func cell(row []interface{}, position int) string {
+	if position >= len(row) || row[position] == nil {
+		return ""
+	}
+	if text, ok := row[position].(string); ok {
+		return strings.TrimSpace(text)
+	}
+	return strings.TrimSpace(fmt.Sprint(row[position]))
+}
+
+func blank(row []interface{}) bool {
+	for position := range row {
+		if cell(row, position) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+var rangeStart = regexp.MustCompile(`![A-Za-z]+([0-9]+)`)
+
+// startRow is the sheet row number of the first row of readRange.
+func startRow(readRange string) int {
+	match := rangeStart.FindStringSubmatch(readRange)
+	if match == nil {
+		return 1
+	}
+	row, err := strconv.Atoi(match[1])
+	if err != nil || row < 1 {
+		return 1
+	}
+	return row
+}
@@ -0,0 +1,129 @@
+package spreadsheet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/model"
+)
+
+func row(cells ...interface{}) []interface{} {
+	return cells
+}
+
+func TestParseRows(t *testing.T) {
+	joined := time.Date(2023, 3, 1, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		values  [][]interface{}
+		schema  Schema
+		want    []User
+		invalid []RowError
+	}{
+		{
+			name: "swedish headers below a title",
+			values: [][]interface{}{
+				row("Medlemmar 2024"),
+				row(),
+				row("Telefon", "Namn", "E-post", "Nivå", "Roller", "Medlem sedan"),
+				row(701234567, "Anna Andersson", "anna@example.com", "advanced", "organizer, treasurer", "2023-03-01"),
+			},
+			schema: Schema{Range: "Medlemmar!A1:Z", Columns: DefaultColumns},
+			want: []User{{
+				Name:     "Anna Andersson",
+				Email:    "anna@example.com",
+				Level:    model.Advanced,
+				Phone:    "701234567",
+				Roles:    []model.Role{model.Organizer, model.Treasurer, model.Member},
+				JoinedAt: &joined,
+			}},
+		},
+		{
+			name: "configured header",
+			values: [][]interface{}{
+				row("Name", "Kontakt", "Email"),
+				row("Anna Andersson", "anna@example.com", "old@example.com"),
+			},
+			schema: Schema{Columns: mustParseColumns(t, "email=Kontakt")},
+			want:   []User{{Name: "Anna Andersson", Email: "anna@example.com", Roles: []model.Role{model.Member}}},
+		},
+		{
+			name: "legacy columns",
+			values: [][]interface{}{
+				row("Stockholm Footvolley"),
+				row("Anna Andersson", "anna@example.com", "MEDIUM", "0701234567", "admin"),
+				row("Bo Berg", "bo@example.com"),
+			},
+			schema: Schema{Columns: DefaultColumns},
+			want: []User{
+				{Name: "Anna Andersson", Email: "anna@example.com", Level: model.Medium, Phone: "0701234567", Roles: []model.Role{model.Admin, model.Member}},
+				{Name: "Bo Berg", Email: "bo@example.com", Roles: []model.Role{model.Member}},
+			},
+		},
+		{
+			name: "malformed rows",
+			values: [][]interface{}{
+				row("Name", "Email", "Level", "Roles", "Expires"),
+				row("No Email", "", "BASIC"),
+				row("Bad Email", "anna.example.com"),
+				row(nil, nil, nil),
+				row("Anna Andersson", "anna@example.com", "expert", "organizer, coach", "31/12/2024"),
+				row("Anna Again", "ANNA@example.com"),
+			},
+			schema: Schema{Range: "Sheet1!A2:E", Columns: DefaultColumns},
+			want:   []User{{Name: "Anna Andersson", Email: "anna@example.com", Level: model.Basic, Roles: []model.Role{model.Organizer, model.Member}}},
+			invalid: []RowError{
+				{Row: 3, Field: FieldEmail, Reason: "missing email"},
+				{Row: 4, Field: FieldEmail, Reason: `invalid email "anna.example.com"`},
+				{Row: 6, Field: FieldLevel, Reason: `unknown level "expert"`},
+				{Row: 6, Field: FieldRoles, Reason: `unknown role "coach"`},
+				{Row: 6, Field: FieldExpires, Reason: `invalid date "31/12/2024"`},
+				{Row: 7, Field: FieldEmail, Reason: "ANNA@example.com is already on row 6"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users, invalid := ParseRows(test.values, test.schema)
+			if !reflect.DeepEqual(users, test.want) {
+				t.Errorf("got users %+v, want %+v", users, test.want)
+			}
+			if test.invalid == nil {
+				test.invalid = []RowError{}
+			}
+			if !reflect.DeepEqual(invalid, test.invalid) {
+				t.Errorf("got invalid rows %v, want %v", invalid, test.invalid)
+			}
+		})
+	}
+}
+
+func mustParseColumns(t *testing.T, mappings ...string) map[string][]string {
+	t.Helper()
+	columns, err := ParseColumns(mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return columns
+}
+
+func TestParseColumns(t *testing.T) {
+	columns := mustParseColumns(t, "email=Kontakt", "email= Mail 2 ", "", "name=Fullständigt namn")
+	if want := []string{"kontakt", "mail 2"}; !reflect.DeepEqual(columns[FieldEmail], want) {
+		t.Errorf("got email headers %q, want %q", columns[FieldEmail], want)
+	}
+	if want := []string{"fullständigt namn"}; !reflect.DeepEqual(columns[FieldName], want) {
+		t.Errorf("got name headers %q, want %q", columns[FieldName], want)
+	}
+	if !reflect.DeepEqual(columns[FieldLevel], DefaultColumns[FieldLevel]) {
+		t.Errorf("got level headers %q, want the defaults", columns[FieldLevel])
+	}
+
+	for _, mapping := range []string{"email", "email=", "shoe size=Skostorlek"} {
+		if _, err := ParseColumns([]string{mapping}); err == nil {
+			t.Errorf("ParseColumns(%q) succeeded", mapping)
+		}
+	}
+}
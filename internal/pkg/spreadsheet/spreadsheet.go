@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/logging"
 	"github.com/stockholmfootvolley/booking/internal/pkg/model"
@@ -15,25 +16,42 @@ import (
 type Client struct {
 	Service       *sheets.Service
 	SpreadsheetID string
+	Schema        Schema
 	Logger        *logging.Logger
 }
 
 type User struct {
-	Name  string      `json:"name"`
-	Email string      `json:"email"`
-	Level model.Level `json:"level"`
+	Name       string       `json:"name"`
+	Email      string       `json:"email"`
+	Level      model.Level  `json:"level"`
+	Phone      string       `json:"phone,omitempty"`
+	Roles      []model.Role `json:"roles"`
+	Membership string       `json:"membership,omitempty"`
+	JoinedAt   *time.Time   `json:"joined_at,omitempty"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+}
+
+var ErrUserNotFound = errors.New("not found")
+
+// Report tells how reading the members went.
+type Report struct {
+	Members int        `json:"members"`
+	Invalid []RowError `json:"invalid_rows"`
 }
 
 type API interface {
 	GetUsers() ([]User, error)
 	GetUser(email string) (*User, error)
+	// Reload reads the members again.
+	Reload() (*Report, error)
 }
 
-const (
-	ReadRange = "Sheet1!A:C"
-)
+// Reader reads all members, with the rows that could not be read.
+type Reader interface {
+	ReadUsers() ([]User, []RowError, error)
+}
 
-func New(serviceAccount string, spreadsheetId string, logger *logging.Logger) (*Client, error) {
+func New(serviceAccount string, spreadsheetId string, schema Schema, logger *logging.Logger) (*Client, error) {
 	service, err := getClient(serviceAccount, logger)
 	if err != nil {
 		logger.Log(logging.Entry{
@@ -46,9 +64,17 @@ func New(serviceAccount string, spreadsheetId string, logger *logging.Logger) (*
 		return nil, err
 	}
 
+	if schema.Range == "" {
+		schema.Range = DefaultRange
+	}
+	if schema.Columns == nil {
+		schema.Columns = DefaultColumns
+	}
+
 	return &Client{
 		SpreadsheetID: spreadsheetId,
 		Service:       service,
+		Schema:        schema,
 		Logger:        logger,
 	}, nil
 
@@ -70,8 +96,9 @@ func getClient(serviceAccount string, logger *logging.Logger) (*sheets.Service,
 	return sheets.NewService(ctx, option.WithCredentials(credentials))
 }
 
-func (c *Client) GetUsers() ([]User, error) {
-	resp, err := c.Service.Spreadsheets.Values.Get(c.SpreadsheetID, ReadRange).Do()
+// ReadUsers reads the members in the range of the schema.
+func (c *Client) ReadUsers() ([]User, []RowError, error) {
+	resp, err := c.Service.Spreadsheets.Values.Get(c.SpreadsheetID, c.Schema.Range).Do()
 	if err != nil {
 		c.Logger.Log(logging.Entry{
 			Severity: logging.Error,
@@ -80,30 +107,28 @@ func (c *Client) GetUsers() ([]User, error) {
 				"error":   err,
 			}},
 		)
+		return nil, nil, err
+	}
+	if len(resp.Values) == 0 {
+		return nil, nil, errors.New("sheet has no header")
 	}
 
-	users := []User{}
-	for _, row := range resp.Values[1:] {
-
-		if len(row) < 2 {
-			continue
-		}
-
-		if len(row) == 2 {
-			row = append(row, "")
-		}
-
-		level := row[2].(string)
-		modelLevel := model.StringToLevel(level)
-
-		users = append(users, User{
-			Name:  row[0].(string),
-			Email: row[1].(string),
-			Level: modelLevel,
-		})
+	users, invalid := ParseRows(resp.Values, c.Schema)
+	if len(invalid) > 0 {
+		c.Logger.Log(logging.Entry{
+			Severity: logging.Warning,
+			Payload: map[string]interface{}{
+				"message": "member sheet has malformed rows",
+				"rows":    invalid,
+			}},
+		)
 	}
+	return users, invalid, nil
+}
 
-	return users, nil
+func (c *Client) GetUsers() ([]User, error) {
+	users, _, err := c.ReadUsers()
+	return users, err
 }
 
 func (c *Client) GetUser(email string) (*User, error) {
@@ -111,12 +136,23 @@ func (c *Client) GetUser(email string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	return findUser(users, email)
+}
 
+// Reload only reports on the members, the client does not cache them.
+func (c *Client) Reload() (*Report, error) {
+	users, invalid, err := c.ReadUsers()
+	if err != nil {
+		return nil, err
+	}
+	return &Report{Members: len(users), Invalid: invalid}, nil
+}
+
+func findUser(users []User, email string) (*User, error) {
 	for _, user := range users {
 		if strings.EqualFold(user.Email, email) {
 			return &user, nil
 		}
 	}
-
-	return nil, errors.New("not found")
+	return nil, ErrUserNotFound
 }
@@ -0,0 +1,262 @@
+package swish
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Statuses of payment requests.
+const (
+	StatusCreated   = "CREATED"
+	StatusPaid      = "PAID"
+	StatusDeclined  = "DECLINED"
+	StatusError     = "ERROR"
+	StatusCancelled = "CANCELLED"
+)
+
+var (
+	ErrCommerceDisabled        = errors.New("swish payment requests are not enabled")
+	ErrPaymentRequestNotFound  = errors.New("payment request not found")
+	ErrInvalidPaymentRequestID = errors.New("invalid payment request id")
+)
+
+// Amount is in öre. Swish writes amounts in SEK with two decimals.
+type Amount int64
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%d.%02d"`, a/100, a%100)), nil
+}
+
+func (a *Amount) UnmarshalJSON(content []byte) error {
+	amount, err := ParseAmount(strings.Trim(string(content), `"`))
+	if err != nil {
+		return err
+	}
+	*a = Amount(amount)
+	return nil
+}
+
+// PaymentRequest asks a member to pay in the Swish app, the Swish Commerce
+// API names the fields.
+type PaymentRequest struct {
+	ID                    string `json:"id,omitempty"`
+	PayeePaymentReference string `json:"payeePaymentReference,omitempty"`
+	PaymentReference      string `json:"paymentReference,omitempty"`
+	CallbackURL           string `json:"callbackUrl"`
+	// PayerAlias is the phone number of the payer in e-commerce requests.
+	// M-commerce requests leave it out and open the app with Token instead.
+	PayerAlias   string `json:"payerAlias,omitempty"`
+	PayeeAlias   string `json:"payeeAlias"`
+	Amount       Amount `json:"amount"`
+	Currency     string `json:"currency"`
+	Message      string `json:"message,omitempty"`
+	Status       string `json:"status,omitempty"`
+	DatePaid     string `json:"datePaid,omitempty"`
+	ErrorCode    string `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+	Token        string `json:"token,omitempty"`
+}
+
+// CommerceConfig holds the Swish Commerce settings. The certificate files
+// are the mutual TLS client certificate of the club and, optionally, the
+// CA that signed the Swish server certificate. Without a certificate the
+// client talks to a fake server over plain HTTP.
+type CommerceConfig struct {
+	URL        string
+	PayeeAlias string
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+}
+
+// Commerce talks to the Swish Commerce API.
+type Commerce struct {
+	URL        string
+	PayeeAlias string
+	HTTPClient *http.Client
+}
+
+func NewCommerce(cfg CommerceConfig) (*Commerce, error) {
+	if cfg.URL == "" || cfg.PayeeAlias == "" {
+		return nil, errors.New("the swish commerce url and payee alias are required")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		if cfg.CAFile != "" {
+			ca, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+			}
+			transport.TLSClientConfig.RootCAs = pool
+		}
+	}
+
+	return &Commerce{
+		URL:        strings.TrimSuffix(cfg.URL, "/"),
+		PayeeAlias: cfg.PayeeAlias,
+		HTTPClient: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Create sends the payment request to Swish under a new id.
+func (c *Commerce) Create(ctx context.Context, request PaymentRequest) (*PaymentRequest, error) {
+	id, err := newInstructionID()
+	if err != nil {
+		return nil, err
+	}
+	request.ID = ""
+	request.PayeeAlias = c.PayeeAlias
+	request.Currency = "SEK"
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPut, c.URL+"/api/v2/paymentrequests/"+id, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, responseError(resp)
+	}
+
+	request.ID = id
+	request.Status = StatusCreated
+	request.Token = resp.Header.Get("PaymentRequestToken")
+	return &request, nil
+}
+
+// Get fetches the current state of a payment request.
+func (c *Commerce) Get(ctx context.Context, id string) (*PaymentRequest, error) {
+	if !validInstructionID(id) {
+		return nil, ErrInvalidPaymentRequestID
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"/api/v1/paymentrequests/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrPaymentRequestNotFound
+	default:
+		return nil, responseError(resp)
+	}
+
+	request := &PaymentRequest{}
+	if err := json.NewDecoder(resp.Body).Decode(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// responseError reads the list of errors Swish answers with.
+func responseError(resp *http.Response) error {
+	content, _ := io.ReadAll(resp.Body)
+	failures := []struct {
+		ErrorCode    string `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	}{}
+	if json.Unmarshal(content, &failures) == nil && len(failures) > 0 {
+		return fmt.Errorf("swish answered %s: %s %s", resp.Status, failures[0].ErrorCode, failures[0].ErrorMessage)
+	}
+	return fmt.Errorf("swish answered %s: %s", resp.Status, content)
+}
+
+// newInstructionID returns a payment request id, 32 upper case hex digits.
+func newInstructionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(id)), nil
+}
+
+func validInstructionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// PayerAlias writes a Swedish phone number the way Swish wants it, with
+// the country code and without the leading zero.
+func PayerAlias(phone string) string {
+	digits := NormalizePhone(phone)
+	if strings.HasPrefix(digits, "0") {
+		return "46" + digits[1:]
+	}
+	return digits
+}
+
+// CreatePaymentRequest asks for amount SEK. With a phone number the request
+// goes straight to the Swish app of the payer, without one the returned
+// token opens the app on the device of the caller.
+func (c *Client) CreatePaymentRequest(ctx context.Context, amount int, message string, phone string, callbackURL string) (*PaymentRequest, error) {
+	if c.Commerce == nil {
+		return nil, ErrCommerceDisabled
+	}
+	if runes := []rune(message); len(runes) > maxMessageLength {
+		message = string(runes[:maxMessageLength])
+	}
+
+	request := PaymentRequest{
+		CallbackURL: callbackURL,
+		Amount:      Amount(int64(amount) * 100),
+		Message:     message,
+	}
+	if phone != "" {
+		request.PayerAlias = PayerAlias(phone)
+	}
+	return c.Commerce.Create(ctx, request)
+}
+
+func (c *Client) GetPaymentRequest(ctx context.Context, id string) (*PaymentRequest, error) {
+	if c.Commerce == nil {
+		return nil, ErrCommerceDisabled
+	}
+	return c.Commerce.Get(ctx, id)
+}
@@ -0,0 +1,136 @@
+// Package fakeswish is a stand-in for the Swish Commerce API, so payment
+// requests can be tried without certificates or a Swish account.
+package fakeswish
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stockholmfootvolley/booking/internal/pkg/swish"
+)
+
+// DeclineWord in the message of a payment request makes the payer decline it.
+const DeclineWord = "DECLINE"
+
+// Server answers payment requests and pays them, or declines them, after
+// Delay, calling back like Swish does.
+type Server struct {
+	Delay      time.Duration
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	requests map[string]*swish.PaymentRequest
+}
+
+func New(delay time.Duration) *Server {
+	return &Server{
+		Delay:      delay,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		requests:   map[string]*swish.PaymentRequest{},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/api/v2/paymentrequests/"):
+		s.create(w, r, strings.TrimPrefix(r.URL.Path, "/api/v2/paymentrequests/"))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/paymentrequests/"):
+		s.get(w, strings.TrimPrefix(r.URL.Path, "/api/v1/paymentrequests/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, id string) {
+	request := swish.PaymentRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "PA02", err.Error())
+		return
+	}
+	if request.CallbackURL == "" {
+		writeError(w, http.StatusUnprocessableEntity, "RP03", "Callback URL is missing")
+		return
+	}
+	if request.Amount <= 0 {
+		writeError(w, http.StatusUnprocessableEntity, "PA02", "Amount value is missing or not a valid number")
+		return
+	}
+
+	s.mu.Lock()
+	if _, found := s.requests[id]; found {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "RP06", "A payment request already exists for that id")
+		return
+	}
+	request.ID = id
+	request.Status = swish.StatusCreated
+	s.requests[id] = &request
+	s.mu.Unlock()
+
+	if request.PayerAlias == "" {
+		w.Header().Set("PaymentRequestToken", "fake-"+strings.ToLower(id))
+	}
+	w.Header().Set("Location", "/api/v1/paymentrequests/"+id)
+	w.WriteHeader(http.StatusCreated)
+
+	time.AfterFunc(s.Delay, func() { s.settle(id) })
+}
+
+func (s *Server) get(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	request, found := s.requests[id]
+	var copied swish.PaymentRequest
+	if found {
+		copied = *request
+	}
+	s.mu.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, "RP04", "No payment request found related to a token")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(copied)
+}
+
+// settle pays or declines the request and calls back.
+func (s *Server) settle(id string) {
+	s.mu.Lock()
+	request := s.requests[id]
+	if strings.Contains(strings.ToUpper(request.Message), DeclineWord) {
+		request.Status = swish.StatusDeclined
+		request.ErrorCode = "RF07"
+		request.ErrorMessage = "Transaction declined"
+	} else {
+		request.Status = swish.StatusPaid
+		request.DatePaid = time.Now().UTC().Format(time.RFC3339)
+		request.PaymentReference = strings.ToUpper(fmt.Sprintf("%x", time.Now().UnixNano()))
+	}
+	callback := *request
+	s.mu.Unlock()
+
+	body, err := json.Marshal(callback)
+	if err != nil {
+		log.Printf("could not encode callback for %s: %v", id, err)
+		return
+	}
+	resp, err := s.HTTPClient.Post(callback.CallbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("could not call back for %s: %v", id, err)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("called back %s with %s: %s", callback.CallbackURL, callback.Status, resp.Status)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode([]map[string]string{{"errorCode": code, "errorMessage": message}})
+}
@@ -0,0 +1,43 @@
+package swish
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// LocalRenderer draws QR codes in-process.
+type LocalRenderer struct{}
+
+func (LocalRenderer) Render(payee string, amount int, message string, format Format, size int) ([]byte, error) {
+	code, err := qrcode.New(Payload(payee, amount, message), qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == SVG {
+		return svg(code.Bitmap(), size), nil
+	}
+	return code.PNG(size)
+}
+
+// svg draws every dark module as a unit square, the viewBox scales the
+// code up to size.
+func svg(bitmap [][]bool, size int) []byte {
+	modules := len(bitmap)
+
+	path := strings.Builder{}
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	return []byte(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+			`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		size, size, modules, modules, modules, modules, path.String()))
+}
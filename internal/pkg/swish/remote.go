@@ -0,0 +1,68 @@
+package swish
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const (
+	URL string = "https://mpc.getswish.net/qrg-swish/api/v1/prefilled"
+)
+
+// RemoteRenderer asks the Swish QR code generator for the image.
+type RemoteRenderer struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+func NewRemoteRenderer() *RemoteRenderer {
+	return &RemoteRenderer{
+		URL:        URL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (r *RemoteRenderer) Render(payee string, amount int, message string, format Format, size int) ([]byte, error) {
+	bodyJson := map[string]interface{}{
+		"format": string(format),
+		"size":   size,
+		"payee": map[string]interface{}{
+			"value":    payee,
+			"editable": false,
+		},
+		"amount": map[string]interface{}{
+			"value":    amount,
+			"editable": false,
+		},
+		"message": map[string]interface{}{
+			"value":    message,
+			"editable": true,
+		},
+		"transparent": true,
+	}
+
+	body, err := json.Marshal(bodyJson)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.HTTPClient.Post(r.URL, mime.TypeByExtension(".json"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	image, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("qr code generator answered %s: %s", resp.Status, image)
+	}
+	return image, nil
+}
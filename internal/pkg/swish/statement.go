@@ -0,0 +1,266 @@
+package swish
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrNoHeader = errors.New("statement has no header with a date and an amount column")
+
+// Transaction is an incoming payment read from a Swish or bank statement.
+type Transaction struct {
+	Line int       `json:"line"`
+	Date time.Time `json:"date"`
+	// Amount is in öre.
+	Amount    int64  `json:"amount"`
+	Message   string `json:"message"`
+	Name      string `json:"name,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+
+// StatementError tells which line of a statement could not be read.
+type StatementError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// column names used by Swish and the banks, in lower case
+var columns = map[string][]string{
+	"date":      {"date", "datum", "transaktionsdag", "bokföringsdag", "bokforingsdag", "betalningsdag", "valutadag"},
+	"amount":    {"amount", "belopp"},
+	"message":   {"message", "meddelande", "text", "beskrivning"},
+	"name":      {"name", "namn", "betalare", "avsändare namn", "avsandare namn", "betalarens namn"},
+	"phone":     {"phone", "telefon", "telefonnummer", "mobilnummer", "avsändare", "avsandare", "betalarnummer"},
+	"reference": {"reference", "referens", "betalningsreferens", "transaktionsreferens", "transaktions-id", "transaction id"},
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// ParseStatement reads the incoming payments of a CSV statement. Columns
+// are found by their header, title lines before the header are skipped and
+// so are outgoing payments. Comma and semicolon separated files are read.
+func ParseStatement(r io.Reader) ([]Transaction, []StatementError, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comma = separator(content)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	records := [][]string{}
+	lines := []int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	header, start := findHeader(records)
+	if header == nil {
+		return nil, nil, ErrNoHeader
+	}
+
+	transactions := []Transaction{}
+	invalid := []StatementError{}
+	for index, record := range records[start:] {
+		line := lines[start+index]
+		if blank(record) {
+			continue
+		}
+
+		transaction, err := header.transaction(record)
+		if err != nil {
+			invalid = append(invalid, StatementError{Line: line, Reason: err.Error()})
+			continue
+		}
+		if transaction.Amount <= 0 {
+			continue
+		}
+		transaction.Line = line
+		if transaction.Reference == "" {
+			transaction.Reference = transaction.fingerprint()
+		}
+		transactions = append(transactions, *transaction)
+	}
+	return transactions, invalid, nil
+}
+
+// separator guesses the separator from the line with most of them.
+func separator(content []byte) rune {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	commas, semicolons := 0, 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if n := strings.Count(line, ","); n > commas {
+			commas = n
+		}
+		if n := strings.Count(line, ";"); n > semicolons {
+			semicolons = n
+		}
+	}
+	if semicolons >= commas {
+		return ';'
+	}
+	return ','
+}
+
+type header map[string]int
+
+// findHeader returns the columns of the first record naming a date and an
+// amount column, and the index of the record after it.
+func findHeader(records [][]string) (header, int) {
+	for index, record := range records {
+		h := header{}
+		for position, name := range record {
+			name = strings.ToLower(strings.TrimSpace(name))
+			for field, aliases := range columns {
+				if _, found := h[field]; found {
+					continue
+				}
+				for _, alias := range aliases {
+					if name == alias {
+						h[field] = position
+					}
+				}
+			}
+		}
+		_, hasDate := h["date"]
+		_, hasAmount := h["amount"]
+		if hasDate && hasAmount {
+			return h, index + 1
+		}
+	}
+	return nil, 0
+}
+
+func (h header) value(record []string, field string) string {
+	position, found := h[field]
+	if !found || position >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[position])
+}
+
+func (h header) transaction(record []string) (*Transaction, error) {
+	date, err := parseDate(h.value(record, "date"))
+	if err != nil {
+		return nil, err
+	}
+	amount, err := ParseAmount(h.value(record, "amount"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{
+		Date:      date,
+		Amount:    amount,
+		Message:   h.value(record, "message"),
+		Name:      h.value(record, "name"),
+		Phone:     h.value(record, "phone"),
+		Reference: h.value(record, "reference"),
+	}, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// ParseAmount reads an amount in SEK, written the Swedish way or not, and
+// returns it in öre.
+func ParseAmount(value string) (int64, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, value)
+	cleaned = strings.TrimSuffix(strings.TrimSuffix(cleaned, "SEK"), "kr")
+
+	// 1.234,50 and 1,234.50 both mean 1234.50
+	lastComma := strings.LastIndex(cleaned, ",")
+	lastDot := strings.LastIndex(cleaned, ".")
+	if lastComma > lastDot {
+		cleaned = strings.ReplaceAll(cleaned, ".", "")
+		cleaned = strings.Replace(cleaned, ",", ".", 1)
+	} else {
+		cleaned = strings.ReplaceAll(cleaned, ",", "")
+	}
+
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if amount < 0 {
+		return int64(amount*100 - 0.5), nil
+	}
+	return int64(amount*100 + 0.5), nil
+}
+
+// NormalizePhone keeps the digits of a Swedish phone number, written
+// nationally.
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if strings.HasPrefix(digits, "0046") {
+		digits = "0" + digits[4:]
+	} else if strings.HasPrefix(digits, "46") && len(digits) > 9 {
+		digits = "0" + digits[2:]
+	}
+	return digits
+}
+
+// fingerprint stands in for the reference of statements without one, so
+// importing the same statement twice is recognized.
+func (t *Transaction) fingerprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s;%d;%s;%s;%s",
+		t.Date.Format(time.RFC3339), t.Amount, t.Message, t.Name, NormalizePhone(t.Phone))))
+	return fmt.Sprintf("statement-%x", sum[:8])
+}
+
+func blank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
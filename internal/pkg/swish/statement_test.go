@@ -0,0 +1,170 @@
+package swish
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "100", want: 10000},
+		{value: "100,00", want: 10000},
+		{value: "99.5", want: 9950},
+		{value: "1 234,50", want: 123450},
+		{value: "1\u00a0234,50", want: 123450},
+		{value: "1.234,50", want: 123450},
+		{value: "1,234.50", want: 123450},
+		{value: "100,00 kr", want: 10000},
+		{value: "100 SEK", want: 10000},
+		{value: "0,10", want: 10},
+		{value: "-50,00", want: -5000},
+		{value: "", wantErr: true},
+		{value: "hundra", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseAmount(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got %d, %v", got, err)
+			}
+			if got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	date := func(value string) time.Time {
+		t, _ := parseDate(value)
+		return t
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Transaction
+		invalid []StatementError
+	}{
+		{
+			name: "swish report",
+			content: strings.Join([]string{
+				"Swish-rapport;;;;",
+				"Period 2024-01-01 - 2024-01-31;;;;",
+				"Datum;Belopp;Meddelande;Avsändare namn;Avsändare",
+				"2024-01-09;100,00;BASIC 2024-01-09;Andersson, Anna;+46701234567",
+				"2024-01-10;-50,00;Återbetalning;Bo Berg;0701111111",
+				"2024-01-11;1 234,50;Gåva;Bo Berg;0701111111",
+				";;;;",
+				"2024-01-12;hundra;BASIC 2024-01-12;Bo Berg;0701111111",
+			}, "\n"),
+			want: []Transaction{
+				{Line: 4, Date: date("2024-01-09"), Amount: 10000, Message: "BASIC 2024-01-09", Name: "Andersson, Anna", Phone: "+46701234567"},
+				{Line: 6, Date: date("2024-01-11"), Amount: 123450, Message: "Gåva", Name: "Bo Berg", Phone: "0701111111"},
+			},
+			invalid: []StatementError{{Line: 8, Reason: `invalid amount "hundra"`}},
+		},
+		{
+			name: "comma separated",
+			content: strings.Join([]string{
+				"\ufeffDate,Amount,Message,Name,Phone,Reference",
+				`2024-01-09 18:30,"1,234.50",BASIC 2024-01-09,Anna Andersson,0701234567,ref-1`,
+				"01/09/2024,100,BASIC 2024-01-09,Bo Berg,,ref-2",
+			}, "\n"),
+			want: []Transaction{
+				{Line: 2, Date: date("2024-01-09 18:30"), Amount: 123450, Message: "BASIC 2024-01-09", Name: "Anna Andersson", Phone: "0701234567", Reference: "ref-1"},
+			},
+			invalid: []StatementError{{Line: 3, Reason: `invalid date "01/09/2024"`}},
+		},
+		{
+			// as many commas as semicolons is a Swedish file
+			name:    "semicolon with decimal commas",
+			content: "Bokföringsdag;Belopp\n2024-01-09;1,00\n",
+			want:    []Transaction{{Line: 2, Date: date("2024-01-09"), Amount: 100}},
+			invalid: []StatementError{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transactions, invalid, err := ParseStatement(strings.NewReader(test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(invalid) != len(test.invalid) {
+				t.Errorf("got invalid lines %+v, want %+v", invalid, test.invalid)
+			}
+			for index := range invalid {
+				if index < len(test.invalid) && invalid[index] != test.invalid[index] {
+					t.Errorf("invalid[%d]: got %+v, want %+v", index, invalid[index], test.invalid[index])
+				}
+			}
+
+			if len(transactions) != len(test.want) {
+				t.Fatalf("got transactions %+v, want %+v", transactions, test.want)
+			}
+			for index, transaction := range transactions {
+				want := test.want[index]
+				if want.Reference == "" {
+					if !strings.HasPrefix(transaction.Reference, "statement-") {
+						t.Errorf("transactions[%d]: got reference %q, want a fingerprint", index, transaction.Reference)
+					}
+					want.Reference = transaction.Reference
+				}
+				if !transaction.Date.Equal(want.Date) {
+					t.Errorf("transactions[%d]: got date %v, want %v", index, transaction.Date, want.Date)
+				}
+				want.Date = transaction.Date
+				if transaction != want {
+					t.Errorf("transactions[%d]: got %+v, want %+v", index, transaction, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStatementFingerprint(t *testing.T) {
+	content := "Datum;Belopp;Meddelande\n2024-01-09;100;BASIC 2024-01-09\n2024-01-09;100;BASIC 2024-01-10\n"
+
+	first, _, err := ParseStatement(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := ParseStatement(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].Reference != second[0].Reference || first[1].Reference != second[1].Reference {
+		t.Errorf("references changed between imports: %+v, %+v", first, second)
+	}
+	if first[0].Reference == first[1].Reference {
+		t.Errorf("two payments got the reference %q", first[0].Reference)
+	}
+}
+
+func TestParseStatementWithoutHeader(t *testing.T) {
+	_, _, err := ParseStatement(strings.NewReader("2024-01-09;100;BASIC\n"))
+	if !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("got %v, want ErrNoHeader", err)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	for value, want := range map[string]string{
+		"070-123 45 67": "0701234567",
+		"+46701234567":  "0701234567",
+		"0046701234567": "0701234567",
+		"46701234567":   "0701234567",
+		"123 456":       "123456",
+		"":              "",
+	} {
+		if got := NormalizePhone(value); got != want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
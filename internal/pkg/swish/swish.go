@@ -1,91 +1,134 @@
 package swish
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"mime"
-	"net/http"
+	"strings"
+	"sync"
 
 	"cloud.google.com/go/logging"
 )
 
 const (
-	URL string = "https://mpc.getswish.net/qrg-swish/api/v1/prefilled"
+	PNG Format = "png"
+	SVG Format = "svg"
+
+	DefaultSize = 300
+	MinSize     = 100
+	MaxSize     = 1000
+
+	// maxMessageLength is the longest message the Swish app accepts.
+	maxMessageLength = 50
+	// maxCached bounds the image cache, it is emptied when full.
+	maxCached = 512
 )
 
+var ErrInvalidFormat = errors.New("format must be png or svg")
+
+// Format is the image format of a QR code.
+type Format string
+
+// Renderer turns a prefilled payment into a QR code image.
+type Renderer interface {
+	Render(payee string, amount int, message string, format Format, size int) ([]byte, error)
+}
+
 type Client struct {
-	Phone  string
-	Logger *logging.Logger
+	Phone    string
+	Logger   *logging.Logger
+	Renderer Renderer
+	// Commerce is nil when payment requests are not enabled.
+	Commerce *Commerce
+
+	mu    sync.Mutex
+	cache map[cacheKey][]byte
+}
+
+type cacheKey struct {
+	amount  int
+	message string
+	format  Format
+	size    int
 }
 
 type API interface {
-	GenerateQrCode(amount int, eventLevel string, eventDate string) (string, error)
+	QrCode(amount int, message string, format Format, size int) ([]byte, error)
+	CreatePaymentRequest(ctx context.Context, amount int, message string, phone string, callbackURL string) (*PaymentRequest, error)
+	GetPaymentRequest(ctx context.Context, id string) (*PaymentRequest, error)
 }
 
-func New(phone string, logger *logging.Logger) (*Client, error) {
+func New(phone string, logger *logging.Logger, renderer Renderer, commerce *Commerce) (*Client, error) {
 	return &Client{
-		Phone:  phone,
-		Logger: logger,
+		Phone:    phone,
+		Logger:   logger,
+		Renderer: renderer,
+		Commerce: commerce,
+		cache:    map[cacheKey][]byte{},
 	}, nil
-
 }
 
-func (c *Client) GenerateQrCode(amount int, eventLevel string, eventDate string) (string, error) {
-
-	bodyJson := map[string]interface{}{
-		"format": "png",
-		"size":   300,
-		"payee": map[string]interface{}{
-			"value":    c.Phone,
-			"editable": false,
-		},
-		"amount": map[string]interface{}{
-			"value":    amount,
-			"editable": false,
-		},
-		"message": map[string]interface{}{
-			"value":    fmt.Sprint(eventLevel, " ", eventDate),
-			"editable": true,
-		},
-		"transparent": true,
+// ParseFormat reads a format name, an empty name is PNG.
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case "", PNG:
+		return PNG, nil
+	case SVG:
+		return SVG, nil
 	}
+	return "", ErrInvalidFormat
+}
 
-	result, err := json.Marshal(bodyJson)
-	if err != nil {
-		c.Logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "could not marshal json",
-				"error":   err,
-			}},
-		)
+// Payload builds the Swish prefilled payment in the "C" format read by
+// the Swish app: payee, amount and message, of which only the message can
+// be edited.
+func Payload(payee string, amount int, message string) string {
+	return fmt.Sprintf("C%s;%d;%s;4", escape(payee), amount, escape(message))
+}
+
+// escape keeps the field separator out of the fields.
+func escape(value string) string {
+	return strings.NewReplacer("%", "%25", ";", "%3B").Replace(value)
+}
+
+// QrCode returns the QR code of a payment of amount SEK to the club. Images
+// are cached, the same payment always gives the same image.
+func (c *Client) QrCode(amount int, message string, format Format, size int) ([]byte, error) {
+	if size < MinSize || size > MaxSize {
+		return nil, fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+	}
+	if format != PNG && format != SVG {
+		return nil, ErrInvalidFormat
+	}
+	if runes := []rune(message); len(runes) > maxMessageLength {
+		message = string(runes[:maxMessageLength])
 	}
 
-	req, err := http.DefaultClient.Post(URL, mime.TypeByExtension(".json"), bytes.NewReader(result))
-	if err != nil {
-		c.Logger.Log(logging.Entry{
-			Severity: logging.Error,
-			Payload: map[string]interface{}{
-				"message": "could request qr code",
-				"error":   err,
-			}},
-		)
+	key := cacheKey{amount: amount, message: message, format: format, size: size}
+	c.mu.Lock()
+	image, found := c.cache[key]
+	c.mu.Unlock()
+	if found {
+		return image, nil
 	}
-	defer req.Body.Close()
 
-	response, err := io.ReadAll(req.Body)
+	image, err := c.Renderer.Render(c.Phone, amount, message, format, size)
 	if err != nil {
 		c.Logger.Log(logging.Entry{
 			Severity: logging.Error,
 			Payload: map[string]interface{}{
-				"message": "could read qr code response",
+				"message": "could not render qr code",
 				"error":   err,
 			}},
 		)
+		return nil, err
 	}
 
-	return base64.StdEncoding.EncodeToString(response), err
+	c.mu.Lock()
+	if len(c.cache) >= maxCached {
+		c.cache = map[cacheKey][]byte{}
+	}
+	c.cache[key] = image
+	c.mu.Unlock()
+	return image, nil
 }
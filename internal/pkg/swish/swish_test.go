@@ -0,0 +1,153 @@
+package swish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// testLogger logs nowhere. Entries are buffered and never delivered.
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+	client, err := logging.NewClient(context.Background(), "projects/test",
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client.Logger("test")
+}
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payee   string
+		amount  int
+		message string
+		want    string
+	}{
+		{"message", "1234567890", 100, "BASIC 2024-01-09", "C1234567890;100;BASIC 2024-01-09;4"},
+		{"no message", "1234567890", 150, "", "C1234567890;150;;4"},
+		{"unicode", "1234567890", 100, "Träning Östermalm", "C1234567890;100;Träning Östermalm;4"},
+		{"separators", "123;456", 100, "50% off; thanks", "C123%3B456;100;50%25 off%3B thanks;4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Payload(test.payee, test.amount, test.message); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// fakeRenderer returns the payload as the image and keeps the payloads it
+// rendered.
+type fakeRenderer struct {
+	err      error
+	rendered []string
+}
+
+func (f *fakeRenderer) Render(payee string, amount int, message string, format Format, size int) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	payload := Payload(payee, amount, message)
+	f.rendered = append(f.rendered, payload)
+	return []byte(payload), nil
+}
+
+func TestQrCodeCache(t *testing.T) {
+	renderer := &fakeRenderer{}
+	client, err := New("1234567890", testLogger(t), renderer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("å", maxMessageLength+10)
+	requests := []struct {
+		amount  int
+		message string
+		format  Format
+		size    int
+	}{
+		{100, "BASIC 2024-01-09", PNG, DefaultSize},
+		{100, "BASIC 2024-01-09", PNG, DefaultSize},
+		{100, "BASIC 2024-01-09", SVG, DefaultSize},
+		{100, "BASIC 2024-01-09", PNG, MaxSize},
+		{150, "BASIC 2024-01-09", PNG, DefaultSize},
+		{100, long, PNG, DefaultSize},
+		// the message is cut to what the app accepts, so this is the same
+		// payment as the one before
+		{100, long[:len(long)-2], PNG, DefaultSize},
+	}
+	for _, request := range requests {
+		image, err := client.QrCode(request.amount, request.message, request.format, request.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(image), "C1234567890;") {
+			t.Errorf("got image %q", image)
+		}
+	}
+
+	if len(renderer.rendered) != 5 {
+		t.Errorf("rendered %d images, want 5: %q", len(renderer.rendered), renderer.rendered)
+	}
+	if last := renderer.rendered[len(renderer.rendered)-1]; last != "C1234567890;100;"+strings.Repeat("å", maxMessageLength)+";4" {
+		t.Errorf("got payload %q, want the message cut to %d characters", last, maxMessageLength)
+	}
+}
+
+func TestQrCodeErrors(t *testing.T) {
+	renderer := &fakeRenderer{err: errors.New("renderer down")}
+	client, err := New("1234567890", testLogger(t), renderer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.QrCode(100, "", PNG, MinSize-1); err == nil {
+		t.Error("rendered a code below the minimum size")
+	}
+	if _, err := client.QrCode(100, "", Format("gif"), DefaultSize); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("got %v, want ErrInvalidFormat", err)
+	}
+	if _, err := client.QrCode(100, "", PNG, DefaultSize); !errors.Is(err, renderer.err) {
+		t.Fatalf("got %v, want the renderer error", err)
+	}
+
+	// failures are not cached
+	renderer.err = nil
+	if _, err := client.QrCode(100, "", PNG, DefaultSize); err != nil {
+		t.Fatal(err)
+	}
+	if len(renderer.rendered) != 1 {
+		t.Errorf("rendered %q, want one image", renderer.rendered)
+	}
+}
+
+func TestLocalRenderer(t *testing.T) {
+	image, err := LocalRenderer{}.Render("1234567890", 100, "BASIC 2024-01-09", PNG, DefaultSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(image, []byte("\x89PNG")) {
+		t.Errorf("got %q, want a PNG image", image[:8])
+	}
+
+	image, err = LocalRenderer{}.Render("1234567890", 100, "BASIC 2024-01-09", SVG, DefaultSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(image, []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="300" height="300"`)) {
+		t.Errorf("got %q, want an SVG image", image)
+	}
+}